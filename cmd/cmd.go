@@ -3,16 +3,21 @@ package cmd
 import (
 	"bufio"
 	"chord/node"
-	"chord/tools"
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 const (
 	PRINTSTATE = "PRINTSTATE"
 	LOOKUP     = "LOOKUP"
+	TRACE      = "TRACE"
+	RINGCHECK  = "RINGCHECK"
+	RINGSIZE   = "RINGSIZE"
 	STOREFILE  = "STOREFILE"
 	STOREFILES = "STOREFILES"
 	GETFILE    = "GETFILE"
@@ -23,6 +28,9 @@ const (
 // DownloadDir download directory
 const DownloadDir = "download"
 
+// RingSizeTimeout bounds the walk of the RINGSIZE command
+const RingSizeTimeout = 30 * time.Second
+
 const UserInputSeparatorLine = "----------------------------------"
 
 const DirPermission = 0755
@@ -57,6 +65,15 @@ func handleUserInput(command string, chordNode *node.Node, scanner *bufio.Scanne
 		handlePrintState(chordNode)
 	case LOOKUP:
 		handleLookup(chordNode, scanner)
+	case TRACE:
+		handleTrace(chordNode, scanner)
+	case RINGCHECK:
+		// a script (e.g. CI) piping the commands learns the outcome from the exit status
+		if !handleRingCheck(chordNode) && scriptMode() {
+			handleQuit(chordNode, scanner, 1)
+		}
+	case RINGSIZE:
+		handleRingSize(chordNode)
 	case STOREFILE:
 		handleStoreFile(chordNode, scanner)
 	case STOREFILES:
@@ -64,7 +81,7 @@ func handleUserInput(command string, chordNode *node.Node, scanner *bufio.Scanne
 	case GETFILE:
 		handleGetFile(chordNode, scanner)
 	case QUIT:
-		handleQuit(chordNode)
+		handleQuit(chordNode, scanner, 0)
 	case CLEAR:
 		handleClear()
 	default:
@@ -93,10 +110,12 @@ func handleLookup(chordNode *node.Node, scanner *bufio.Scanner) {
 		fmt.Println(UserInputSeparatorLine)
 		fmt.Printf("Command: %s %s\n", LOOKUP, filename)
 
-		identifier := tools.GenerateIdentifier(filename)
+		identifier := chordNode.GenerateIdentifier(filename)
 		fmt.Printf("Identifier of %s: %s\n", filename, identifier)
 
-		successor, err := CmdLookUp(chordNode.GetInfo(), filename)
+		ctx, stop := commandContext()
+		successor, err := CmdLookUp(ctx, chordNode, filename)
+		stop()
 		if err != nil {
 			fmt.Printf("Lookup %s failed: %v\n", filename, err)
 		} else {
@@ -108,6 +127,65 @@ func handleLookup(chordNode *node.Node, scanner *bufio.Scanner) {
 	}
 }
 
+func handleTrace(chordNode *node.Node, scanner *bufio.Scanner) {
+	fmt.Print("Enter key to trace: ")
+	if scanner.Scan() {
+		filename := scanner.Text()
+		fmt.Println(UserInputSeparatorLine)
+		fmt.Printf("Command: %s %s\n", TRACE, filename)
+
+		ctx, stop := commandContext()
+		successor, trace, err := CmdTrace(ctx, chordNode, filename)
+		stop()
+		trace.Print()
+		if err != nil {
+			fmt.Printf("Trace %s failed: %v\n", filename, err)
+		} else {
+			fmt.Printf("Trace %s success: ", filename)
+			successor.PrintInfo()
+		}
+
+		fmt.Println(UserInputSeparatorLine)
+	}
+}
+
+// handleRingCheck returns true if the ring is healthy.
+func handleRingCheck(chordNode *node.Node) bool {
+	fmt.Println(UserInputSeparatorLine)
+	fmt.Printf("Command: %s\n", RINGCHECK)
+
+	ctx, stop := commandContext()
+	report := CmdRingCheck(ctx, chordNode)
+	stop()
+	report.Print()
+	healthy := report.Healthy()
+	if healthy {
+		fmt.Println("Ring check passed")
+	} else {
+		fmt.Println("Ring check failed")
+	}
+
+	fmt.Println(UserInputSeparatorLine)
+	return healthy
+}
+
+func handleRingSize(chordNode *node.Node) {
+	fmt.Println(UserInputSeparatorLine)
+	fmt.Printf("Command: %s\n", RINGSIZE)
+
+	ctx, stop := commandContext()
+	estimate, exact, err := CmdRingSize(ctx, chordNode)
+	stop()
+	fmt.Printf("Ring size: about %d (estimated)\n", estimate)
+	if err != nil {
+		fmt.Printf("Ring size: the walk failed: %v\n", err)
+	} else {
+		fmt.Printf("Ring size: %d (walked)\n", exact)
+	}
+
+	fmt.Println(UserInputSeparatorLine)
+}
+
 func handleStoreFile(chordNode *node.Node, scanner *bufio.Scanner) {
 	fmt.Print("Enter the file location: ")
 	if scanner.Scan() {
@@ -115,7 +193,9 @@ func handleStoreFile(chordNode *node.Node, scanner *bufio.Scanner) {
 		fmt.Println(UserInputSeparatorLine)
 		fmt.Printf("Command: %s %s\n", STOREFILE, location)
 
-		targetNode, err := CmdStoreFile(chordNode.GetInfo(), location)
+		ctx, stop := commandContext()
+		targetNode, err := CmdStoreFile(ctx, chordNode, location)
+		stop()
 		if err != nil {
 			fmt.Printf("Storing file %s failed: %v\n", location, err)
 		} else {
@@ -134,7 +214,9 @@ func handleStoreFiles(chordNode *node.Node, scanner *bufio.Scanner) {
 		fmt.Println(UserInputSeparatorLine)
 		fmt.Printf("Command: %s %s\n", STOREFILES, dirLocation)
 
-		err := getAndStoreFilesInDirectory(dirLocation, chordNode)
+		ctx, stop := commandContext()
+		err := getAndStoreFilesInDirectory(ctx, dirLocation, chordNode)
+		stop()
 		if err != nil {
 			fmt.Printf("Storing files in directory %s failed: %v\n", dirLocation, err)
 		}
@@ -150,7 +232,9 @@ func handleGetFile(chordNode *node.Node, scanner *bufio.Scanner) {
 		fmt.Println(UserInputSeparatorLine)
 		fmt.Printf("Command: %s %s\n", GETFILE, filename)
 
-		targetNode, fileContent, err := CmdGetFile(chordNode.GetInfo(), filename)
+		ctx, stop := commandContext()
+		targetNode, fileContent, err := CmdGetFile(ctx, chordNode, filename)
+		stop()
 		if err != nil {
 			fmt.Printf("Getting file %s failed: %v\n", filename, err)
 		} else {
@@ -168,12 +252,30 @@ func handleGetFile(chordNode *node.Node, scanner *bufio.Scanner) {
 	}
 }
 
-func handleQuit(chordNode *node.Node) {
+// handleQuit leaves the ring and exits the process with the status, unless the user cancels it.
+func handleQuit(chordNode *node.Node, scanner *bufio.Scanner, status int) {
 	fmt.Println(UserInputSeparatorLine)
 	fmt.Printf("Command: %s\n", QUIT)
-	CmdQuit(chordNode)
+	report, err := CmdQuit(chordNode, false, nil)
+	if err != nil {
+		fmt.Printf("Handing off the files failed: %v\n", err)
+		fmt.Printf("%d of %d files moved\n", report.FilesMoved, report.FilesTotal)
+		fmt.Print("Quit anyway, the files not moved may be lost? [y/N]: ")
+		if !scanner.Scan() || strings.ToUpper(strings.TrimSpace(scanner.Text())) != "Y" {
+			fmt.Println("Quit canceled, the node keeps running")
+			fmt.Println(UserInputSeparatorLine)
+			return
+		}
+		report, _ = CmdQuit(chordNode, true, report) // the files already moved are not sent again
+	}
+	fmt.Printf("%d of %d files moved, backups handed over: %t\n", report.FilesMoved, report.FilesTotal, report.BackupsHandedOver)
+	if report.Complete() {
+		fmt.Println("Handoff completed")
+	} else {
+		fmt.Println("Handoff not completed")
+	}
 	fmt.Println(UserInputSeparatorLine)
-	os.Exit(0)
+	os.Exit(status)
 }
 
 func handleClear() {
@@ -188,13 +290,32 @@ func handleInvalidCommand() {
 
 /*                             Helper function                             */
 
-func getAndStoreFilesInDirectory(dirLocation string, chordNode *node.Node) error {
+// commandContext returns the context of one command, it is canceled when the user presses Ctrl-C,
+// so a long command (e.g. GETFILE) can be aborted without killing the node.
+// stop must be called when the command is done, to restore the default Ctrl-C behavior.
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+// scriptMode tells if the commands come from a script rather than a terminal, i.e. stdin is not a terminal.
+func scriptMode() bool {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice == 0
+}
+
+func getAndStoreFilesInDirectory(ctx context.Context, dirLocation string, chordNode *node.Node) error {
 	return filepath.Walk(dirLocation, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if ctx.Err() != nil {
+			return ctx.Err() // the user aborted the command
+		}
 		if !info.IsDir() {
-			targetNode, err := CmdStoreFile(chordNode.GetInfo(), path)
+			targetNode, err := CmdStoreFile(ctx, chordNode, path)
 			if err != nil {
 				fmt.Printf("Storing file %s failed: %v\n", path, err)
 			} else {
@@ -4,7 +4,7 @@ import (
 	"chord/aes"
 	"chord/config"
 	"chord/node"
-	"chord/tools"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -16,30 +16,53 @@ func CmdPrintState(chordNode *node.Node) {
 	chordNode.PrintState()
 }
 
-func CmdQuit(chordNode *node.Node) {
-	chordNode.Quit()
+// CmdQuit quits the node, previous is the report of the refused quit before, if any, see node.Quit.
+func CmdQuit(chordNode *node.Node, force bool, previous *node.HandoffReport) (*node.HandoffReport, error) {
+	return chordNode.Quit(force, previous)
 }
 
 /*                             Directly operating on local node                             */
 
 /*                             Operating through Node address (nodeInfo)                            */
 
-// the start point of the function is the local node
+// the start point of the function is the local node (chordNode)
 // but throught the start node, we can find the target node
-// then we directly communicate with the target node!
+// then we directly communicate with the target node, using the local node's settings!
+// all of them stop when ctx is done, e.g. when the user presses Ctrl-C
 
 // lookup the successor node of the key in the chord ring
-func CmdLookUp(startNode *node.NodeInfo, filename string) (*node.NodeInfo, error) {
+func CmdLookUp(ctx context.Context, chordNode *node.Node, filename string) (*node.NodeInfo, error) {
 	// step 1: generate the identifier of the filename
-	identifier := tools.GenerateIdentifier(filename)
+	identifier := chordNode.GenerateIdentifier(filename)
 	fmt.Println("The identifier of the filename is", identifier)
 	// step 2: find the successor node of the (filename) identifier
-	targetNode, err := startNode.FindSuccessorIter(identifier)
+	targetNode, err := chordNode.Remote(chordNode.GetInfo()).Lookup(ctx, identifier)
 	return targetNode, err
 }
 
+// lookup the successor node of the key like CmdLookUp, but also return the path of the lookup
+// the trace is always done iteratively, so that every hop can be seen
+func CmdTrace(ctx context.Context, chordNode *node.Node, filename string) (*node.NodeInfo, *node.LookupTrace, error) {
+	identifier := chordNode.GenerateIdentifier(filename)
+	return chordNode.Remote(chordNode.GetInfo()).FindSuccessorIterTrace(ctx, identifier)
+}
+
+// walk the ring from the local node and check its links, successor lists and finger tables
+func CmdRingCheck(ctx context.Context, chordNode *node.Node) *node.RingReport {
+	return chordNode.CheckRing(ctx)
+}
+
+// estimate the size of the ring, and count it exactly by walking the ring from the local node
+// the walk costs one RPC per node, it gives up after RingSizeTimeout
+func CmdRingSize(ctx context.Context, chordNode *node.Node) (int, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, RingSizeTimeout)
+	defer cancel()
+	exact, err := chordNode.CountRing(ctx)
+	return chordNode.EstimateSize(), exact, err
+}
+
 // store the file in the chord ring
-func CmdStoreFile(startNode *node.NodeInfo, location string) (*node.NodeInfo, error) {
+func CmdStoreFile(ctx context.Context, chordNode *node.Node, location string) (*node.NodeInfo, error) {
 	// Step 1: Validate and normalize the file path
 	absPath, err := filepath.Abs(location)
 	if err != nil {
@@ -50,7 +73,7 @@ func CmdStoreFile(startNode *node.NodeInfo, location string) (*node.NodeInfo, er
 	filename := filepath.Base(absPath)
 
 	// Step 3: Perform a "LookUp" to findSuccessorIter the correct node to store the file
-	targetNode, err := CmdLookUp(startNode, filename)
+	targetNode, err := CmdLookUp(ctx, chordNode, filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to lookup the target node: %v", err)
 	}
@@ -70,7 +93,7 @@ func CmdStoreFile(startNode *node.NodeInfo, location string) (*node.NodeInfo, er
 	}
 
 	// Step 6: Store the file content in the target node's storage
-	reply, err := targetNode.StoreFile(filename, fileContent)
+	reply, err := chordNode.Remote(targetNode).StoreFileContext(ctx, filename, fileContent)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get the reply from node %s: %v", targetNode.Identifier.String(), err)
 	}
@@ -81,15 +104,15 @@ func CmdStoreFile(startNode *node.NodeInfo, location string) (*node.NodeInfo, er
 }
 
 // get the file content from the chord ring, also return the target node information
-func CmdGetFile(startNode *node.NodeInfo, filename string) (*node.NodeInfo, []byte, error) {
+func CmdGetFile(ctx context.Context, chordNode *node.Node, filename string) (*node.NodeInfo, []byte, error) {
 	// step 1: find the successor node (targetNode) of the key (filename)
-	targetNode, err := CmdLookUp(startNode, filename)
+	targetNode, err := CmdLookUp(ctx, chordNode, filename)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to lookup the target node: %v", err)
 	}
 
 	// step 2: get the GetFile reply from the target node (successful flag and file content)
-	reply, err := targetNode.GetFile(filename)
+	reply, err := chordNode.Remote(targetNode).GetFileContext(ctx, filename)
 	// if error occurs, it means RPC call failed
 	if err != nil {
 		return targetNode, nil, fmt.Errorf("failed to get the reply from node %s: %v", targetNode.Identifier.String(), err)
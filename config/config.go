@@ -2,6 +2,7 @@ package config
 
 import (
 	"chord/aes"
+	"chord/tools"
 	"crypto/tls"
 	"flag"
 	"fmt"
@@ -21,8 +22,21 @@ type Config struct {
 	StabilizeTime        int
 	FixFingersTime       int
 	CheckPredecessorTime int
+	AdaptiveIntervals    bool // the periodic task intervals adapt to the churn
+	FingersPerTick       int
 	Successors           int
+	Predecessors         int // 0 means the same as Successors
+	PhiThreshold         float64
+	SuspicionFailures    int
+	IdentifierLength     int
+	Hash                 string // the hash the identifiers are cut from
 	Identifier           string
+	IdPolicy             string
+	LookupMode           string
+	VirtualNodes         int
+	Seeds                seedList // bootstrap peers as ip:port, --ja/--jp and the seeds file are added to it
+	SeedsFile            string
+	JoinDeadline         int // seconds
 
 	Mode string // "create" or "join"
 
@@ -48,11 +62,24 @@ func parseFlags() *Config {
 	flag.StringVar(&cfg.Port, "p", Unspecified, "The port that the Chord client will bind to and listen on. Must be specified.")
 	flag.StringVar(&cfg.JoinAddress, "ja", Unspecified, "The IP address of the machine running a Chord node. Must be specified if --jp is specified.")
 	flag.StringVar(&cfg.JoinPort, "jp", Unspecified, "The port that an existing Chord node is bound to and listening on. Must be specified if --ja is specified.")
+	flag.Var(&cfg.Seeds, "seed", "The ip:port of a bootstrap peer, an existing Chord node to join through. Can be repeated. Optional parameter.")
+	flag.StringVar(&cfg.SeedsFile, "seeds", "", "The path to a file listing bootstrap peers, one ip:port per line. Optional parameter.")
+	flag.IntVar(&cfg.JoinDeadline, "joindeadline", 60, "The time in seconds to keep trying the bootstrap peers before giving up the join. Optional parameter, with a value in the range of [1,3600], default is 60.")
 	flag.IntVar(&cfg.StabilizeTime, "ts", 0, "The time in milliseconds between invocations of 'stabilize'. Must be specified, with a value in the range of [1,60000].")
 	flag.IntVar(&cfg.FixFingersTime, "tff", 0, "The time in milliseconds between invocations of 'fix fingers'. Must be specified, with a value in the range of [1,60000].")
 	flag.IntVar(&cfg.CheckPredecessorTime, "tcp", 0, "The time in milliseconds between invocations of 'check predecessor'. Must be specified, with a value in the range of [1,60000].")
+	flag.BoolVar(&cfg.AdaptiveIntervals, "adaptive", false, "Adapt the intervals of 'stabilize', 'fix fingers' and 'check predecessor' to the churn, between a quarter and eight times the configured values. Optional parameter.")
+	flag.IntVar(&cfg.FingersPerTick, "fingerspertick", 1, "The number of finger table entries refreshed by each invocation of 'fix fingers'. Optional parameter, with a value in the range of [1,m], default is 1.")
 	flag.IntVar(&cfg.Successors, "r", 0, "The number of successors maintained by the Chord client. Must be specified, with a value in the range of [1,32].")
-	flag.StringVar(&cfg.Identifier, "i", Unspecified, "The Identifier (ID) assigned to the Chord client which will override the ID computed by the SHA1 sum of the client's IP address and port number. Represented as a string of 40 characters matching [0-9a-fA-F]. Optional parameter.")
+	flag.IntVar(&cfg.Predecessors, "rp", 0, "The number of predecessors maintained by the Chord client. Optional parameter, with a value in the range of [1,32], default is the value of -r.")
+	flag.Float64Var(&cfg.PhiThreshold, "phi", 8, "The suspicion level phi at which the failure detector declares a peer dead. Optional parameter, with a value in the range of [1,50], default is 8.")
+	flag.IntVar(&cfg.SuspicionFailures, "suspect", 3, "The number of failed calls in a row to a peer before the failure detector may declare it dead. Optional parameter, with a value in the range of [1,100], default is 3.")
+	flag.IntVar(&cfg.IdentifierLength, "m", 10, "The length m of the identifiers in bits, the ring has 2^m identifiers. All nodes in a ring must use the same m. Optional parameter, with a value in the range of [1,160] with sha1 and [1,256] with the other hashes, default is 10.")
+	flag.StringVar(&cfg.Hash, "hash", tools.DefaultHash, "The hash the identifiers of the nodes and the files are cut from: 'sha1', 'sha256' or 'sha512/256'. All nodes in a ring must use the same hash. Optional parameter, default is 'sha1'.")
+	flag.StringVar(&cfg.Identifier, "i", Unspecified, "The Identifier (ID) assigned to the Chord client which will override the ID computed by the hash of the client's IP address and port number. Represented as a string of hex characters matching [0-9a-fA-F], as long as the digest of the hash (40 with sha1, 64 with the others). Optional parameter.")
+	flag.StringVar(&cfg.IdPolicy, "idpolicy", "hash", "The policy used to assign the Identifier (ID): 'hash' uses the hash of the client's IP address and port number, 'gap' picks the midpoint of the largest gap in the ring when joining, 'secure' uses the hash of the public key of the TLS certificate, checked by the peers (needs -tls, and excludes -i). Ignored if -i is specified. Optional parameter, default is 'hash'.")
+	flag.StringVar(&cfg.LookupMode, "lookup", "iterative", "The lookup mode: 'iterative' lets the client contact every hop itself, 'recursive' lets each hop forward the request to the next one. Optional parameter, default is 'iterative'.")
+	flag.IntVar(&cfg.VirtualNodes, "vnodes", 1, "The number of identifiers (virtual nodes) of the Chord client in the ring, they share its listener and storage root. Optional parameter, with a value in the range of [1,64], default is 1.")
 	flag.BoolVar(&cfg.AESBool, "aes", false, "Enable AES encryption. Optional parameter.")
 	flag.StringVar(&cfg.AESKeyPath, "aeskey", "", "The path to the AES key file. Must be specified if --aes is specified.")
 	flag.BoolVar(&cfg.TLSBool, "tls", false, "Enable TLS connection. Optional parameter.")
@@ -72,6 +99,11 @@ func ReadConfig() *Config {
 		os.Exit(1)
 	}
 
+	if err := determineSeeds(cfg); err != nil {
+		fmt.Println("Failed to determine seeds:", err)
+		os.Exit(1)
+	}
+
 	determineMode(cfg)
 
 	if err := determineAES(cfg); err != nil {
@@ -117,6 +149,30 @@ func validateConfig(cfg *Config) error {
 		return fmt.Errorf("number of successors must be in the range of [1,32]")
 	}
 
+	if cfg.Predecessors == 0 {
+		cfg.Predecessors = cfg.Successors
+	}
+	if cfg.Predecessors < 1 || cfg.Predecessors > 32 {
+		return fmt.Errorf("number of predecessors must be in the range of [1,32]")
+	}
+
+	if cfg.PhiThreshold < 1 || cfg.PhiThreshold > 50 {
+		return fmt.Errorf("phi threshold must be in the range of [1,50]")
+	}
+
+	if cfg.SuspicionFailures < 1 || cfg.SuspicionFailures > 100 {
+		return fmt.Errorf("number of failures before suspecting a peer dead must be in the range of [1,100]")
+	}
+
+	hashBits := tools.HashBits(cfg.Hash)
+	if hashBits == 0 {
+		return fmt.Errorf("hash must be '%s', '%s' or '%s'", tools.HashSHA1, tools.HashSHA256, tools.HashSHA512256)
+	}
+
+	if cfg.IdentifierLength < 1 || cfg.IdentifierLength > hashBits {
+		return fmt.Errorf("identifier length m must be in the range of [1,%d] with %s", hashBits, cfg.Hash)
+	}
+
 	if (cfg.JoinAddress != Unspecified && cfg.JoinPort == Unspecified) || (cfg.JoinAddress == Unspecified && cfg.JoinPort != Unspecified) {
 		return fmt.Errorf("both --ja and --jp must be specified together")
 	}
@@ -132,13 +188,41 @@ func validateConfig(cfg *Config) error {
 		}
 	}
 
+	if cfg.JoinDeadline < 1 || cfg.JoinDeadline > 3600 {
+		return fmt.Errorf("join deadline must be in the range of [1,3600] seconds")
+	}
+
 	if cfg.Identifier != Unspecified {
-		matched, err := regexp.MatchString("^[0-9a-fA-F]{40}$", cfg.Identifier)
+		matched, err := regexp.MatchString(fmt.Sprintf("^[0-9a-fA-F]{%d}$", hashBits/4), cfg.Identifier)
 		if err != nil || !matched {
 			return fmt.Errorf("invalid Identifier format")
 		}
 	}
 
+	if cfg.IdPolicy != "hash" && cfg.IdPolicy != "gap" && cfg.IdPolicy != "secure" {
+		return fmt.Errorf("identifier policy must be 'hash', 'gap' or 'secure'")
+	}
+	if cfg.IdPolicy == "secure" {
+		if !cfg.TLSBool {
+			return fmt.Errorf("the secure identifier policy needs --tls, the identifier is the hash of the certificate's key")
+		}
+		if cfg.Identifier != Unspecified {
+			return fmt.Errorf("the secure identifier policy can't be used with -i, the identifier must come from the certificate's key")
+		}
+	}
+
+	if cfg.LookupMode != "iterative" && cfg.LookupMode != "recursive" {
+		return fmt.Errorf("lookup mode must be 'iterative' or 'recursive'")
+	}
+
+	if cfg.FingersPerTick < 1 || cfg.FingersPerTick > cfg.IdentifierLength {
+		return fmt.Errorf("number of fingers per tick must be in the range of [1,%d], the number of fingers (-m)", cfg.IdentifierLength)
+	}
+
+	if cfg.VirtualNodes < 1 || cfg.VirtualNodes > 64 {
+		return fmt.Errorf("number of virtual nodes must be in the range of [1,64]")
+	}
+
 	if cfg.AESBool {
 		if cfg.AESKeyPath == "" {
 			return fmt.Errorf("AES key path must be specified if --aes is specified")
@@ -173,7 +257,7 @@ func validateConfig(cfg *Config) error {
 // If the join address and join port are both specified, the mode is "join".
 // Otherwise, the mode is "create".
 func determineMode(cfg *Config) {
-	if cfg.JoinAddress != Unspecified && cfg.JoinPort != Unspecified {
+	if len(cfg.Seeds) > 0 {
 		cfg.Mode = "join"
 	} else {
 		cfg.Mode = "create"
@@ -7,8 +7,10 @@ import (
 
 func (cfg *Config) printJoinInfo() {
 	log.Logger.Print(log.CenterTitle("Join Information", "-"))
-	log.PrintKeyValue("Join Address", cfg.JoinAddress)
-	log.PrintKeyValue("Join Port", cfg.JoinPort)
+	for i, seed := range cfg.Seeds {
+		log.PrintKeyValue(fmt.Sprintf("Seed %d", i), seed)
+	}
+	log.PrintKeyValue("Join Deadline", fmt.Sprintf("%d s", cfg.JoinDeadline))
 }
 
 func (cfg *Config) PrintTimingInfo() {
@@ -16,16 +18,45 @@ func (cfg *Config) PrintTimingInfo() {
 	log.PrintKeyValue("Stabilize Time", fmt.Sprintf("%d ms", cfg.StabilizeTime))
 	log.PrintKeyValue("Fix Fingers Time", fmt.Sprintf("%d ms", cfg.FixFingersTime))
 	log.PrintKeyValue("Check Predecessor Time", fmt.Sprintf("%d ms", cfg.CheckPredecessorTime))
+	log.PrintKeyValue("Adaptive Intervals", cfg.AdaptiveIntervals)
+	log.PrintKeyValue("Fingers Per Tick", cfg.FingersPerTick)
 }
 
 func (cfg *Config) printSuccessors() {
 	log.Logger.Print(log.CenterTitle("Successors", "-"))
 	log.PrintKeyValue("Successors", fmt.Sprintf("%d", cfg.Successors))
+	log.PrintKeyValue("Predecessors", fmt.Sprintf("%d", cfg.Predecessors))
+}
+
+func (cfg *Config) printFailureDetector() {
+	log.Logger.Print(log.CenterTitle("Failure Detector", "-"))
+	log.PrintKeyValue("Phi Threshold", cfg.PhiThreshold)
+	log.PrintKeyValue("Failures In A Row", cfg.SuspicionFailures)
+}
+
+func (cfg *Config) printIdentifierLength() {
+	log.Logger.Print(log.CenterTitle("Identifier Length", "-"))
+	log.PrintKeyValue("Identifier Length (m)", fmt.Sprintf("%d", cfg.IdentifierLength))
+	log.PrintKeyValue("Hash", cfg.Hash)
 }
 
 func (cfg *Config) printIdentifier() {
 	log.Logger.Print(log.CenterTitle("Identifier", "-"))
-	log.PrintKeyValue("Identifier", cfg.Identifier)
+	if cfg.Identifier != Unspecified {
+		log.PrintKeyValue("Identifier", cfg.Identifier)
+	} else {
+		log.PrintKeyValue("Identifier Policy", cfg.IdPolicy)
+	}
+}
+
+func (cfg *Config) printLookupMode() {
+	log.Logger.Print(log.CenterTitle("Lookup Mode", "-"))
+	log.PrintKeyValue("Lookup Mode", cfg.LookupMode)
+}
+
+func (cfg *Config) printVirtualNodes() {
+	log.Logger.Print(log.CenterTitle("Virtual Nodes", "-"))
+	log.PrintKeyValue("Virtual Nodes", cfg.VirtualNodes)
 }
 
 func (cfg *Config) printMode() {
@@ -59,9 +90,15 @@ func (cfg *Config) Print() {
 
 	cfg.printSuccessors()
 
-	if cfg.Identifier != Unspecified {
-		cfg.printIdentifier()
-	}
+	cfg.printFailureDetector()
+
+	cfg.printIdentifierLength()
+
+	cfg.printIdentifier()
+
+	cfg.printLookupMode()
+
+	cfg.printVirtualNodes()
 
 	cfg.printMode()
 
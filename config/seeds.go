@@ -0,0 +1,90 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// seedList collects the repeated -seed flags.
+type seedList []string
+
+func (seeds *seedList) String() string {
+	return strings.Join(*seeds, ",")
+}
+
+func (seeds *seedList) Set(value string) error {
+	*seeds = append(*seeds, value)
+	return nil
+}
+
+// Collect the bootstrap peers from --ja/--jp, the -seed flags and the seeds file, and validate them.
+func determineSeeds(cfg *Config) error {
+	seeds := []string(cfg.Seeds)
+	if cfg.JoinAddress != Unspecified && cfg.JoinPort != Unspecified {
+		seeds = append([]string{net.JoinHostPort(cfg.JoinAddress, cfg.JoinPort)}, seeds...)
+	}
+	if cfg.SeedsFile != "" {
+		fileSeeds, err := readSeedsFile(cfg.SeedsFile)
+		if err != nil {
+			return err
+		}
+		seeds = append(seeds, fileSeeds...)
+	}
+
+	// the same seed may be given twice, e.g. by --ja/--jp and the seeds file
+	seen := make(map[string]bool)
+	cfg.Seeds = nil
+	for _, seed := range seeds {
+		if err := validateSeed(seed); err != nil {
+			return err
+		}
+		if !seen[seed] {
+			seen[seed] = true
+			cfg.Seeds = append(cfg.Seeds, seed)
+		}
+	}
+	return nil
+}
+
+// Read the seeds file, one ip:port per line, empty lines and lines starting with # are ignored.
+func readSeedsFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the seeds file: %w", err)
+	}
+	defer file.Close()
+
+	var seeds []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		seeds = append(seeds, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the seeds file: %w", err)
+	}
+	return seeds, nil
+}
+
+// Validate one seed, it must be ip:port with a port in (1024,65535].
+func validateSeed(seed string) error {
+	host, portStr, err := net.SplitHostPort(seed)
+	if err != nil {
+		return fmt.Errorf("invalid seed %q, it must be ip:port", seed)
+	}
+	if net.ParseIP(host) == nil {
+		return fmt.Errorf("invalid seed %q, invalid ip address format", seed)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 1024 || port > 65535 {
+		return fmt.Errorf("invalid seed %q, port must be in the range of (1024,65535]", seed)
+	}
+	return nil
+}
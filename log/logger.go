@@ -9,6 +9,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -36,6 +37,7 @@ var (
 	infoLogPath  string
 	errorLogPath string
 	allLogPath   string
+	logDirectory = "." // where the log files are created, see SetDirectory
 	Logger       *CombinedLogger
 	titleWidth   = 48
 )
@@ -44,52 +46,62 @@ type CombinedLogger struct {
 	InfoLogger  *log.Logger
 	ErrorLogger *log.Logger
 	verbosity   int
+	openOnce    sync.Once // the files are created with the first message
 }
 
 func init() {
-	verbosity := getVerbosity()
+	Logger = &CombinedLogger{verbosity: getVerbosity()}
+}
 
-	if verbosity < 1 {
-		Logger = &CombinedLogger{nil, nil, verbosity}
-		return
-	}
+// SetDirectory makes the log files be created in dir instead of the working directory.
+// It must be called before the first message, e.g. by a test with its t.TempDir().
+func SetDirectory(dir string) {
+	logDirectory = dir
+}
 
-	timestamp := time.Now().Format("20060102_150405")
-	infoLogPath = fmt.Sprintf("info_%s.log", timestamp)
-	errorLogPath = fmt.Sprintf("error_%s.log", timestamp)
-	allLogPath = fmt.Sprintf("all_%s.log", timestamp)
+// open creates the log files, once, if the verbosity asks for them.
+func (l *CombinedLogger) open() {
+	l.openOnce.Do(func() {
+		if l.verbosity < 1 {
+			return
+		}
 
-	// Open a file for writing info logs.
-	infoLogFile, err := os.OpenFile(infoLogPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
-	if err != nil {
-		log.Printf("Failed to open info log file: %v", err)
-	}
+		timestamp := time.Now().Format("20060102_150405")
+		infoLogPath = filepath.Join(logDirectory, fmt.Sprintf("info_%s.log", timestamp))
+		errorLogPath = filepath.Join(logDirectory, fmt.Sprintf("error_%s.log", timestamp))
+		allLogPath = filepath.Join(logDirectory, fmt.Sprintf("all_%s.log", timestamp))
 
-	// Open a file for writing error logs.
-	errorLogFile, err := os.OpenFile(errorLogPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
-	if err != nil {
-		log.Printf("Failed to open error log file: %v", err)
-	}
+		// Open a file for writing info logs.
+		infoLogFile, err := os.OpenFile(infoLogPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+		if err != nil {
+			log.Printf("Failed to open info log file: %v", err)
+		}
 
-	// Open a file for writing all logs.
-	allLogFile, err := os.OpenFile(allLogPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
-	if err != nil {
-		log.Printf("Failed to open combined log file: %v", err)
-	}
+		// Open a file for writing error logs.
+		errorLogFile, err := os.OpenFile(errorLogPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+		if err != nil {
+			log.Printf("Failed to open error log file: %v", err)
+		}
 
-	infoWriter := io.MultiWriter(infoLogFile, allLogFile)
-	errorWriter := io.MultiWriter(errorLogFile, allLogFile)
+		// Open a file for writing all logs.
+		allLogFile, err := os.OpenFile(allLogPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+		if err != nil {
+			log.Printf("Failed to open combined log file: %v", err)
+		}
 
-	// Create a logger for info messages.
-	InfoLogger := log.New(infoWriter, "INFO: ", log.Ldate|log.Ltime|log.Lmicroseconds)
+		infoWriter := io.MultiWriter(infoLogFile, allLogFile)
+		errorWriter := io.MultiWriter(errorLogFile, allLogFile)
 
-	// Create a logger for error messages.
-	ErrorLogger := log.New(errorWriter, "ERROR: ", log.Ldate|log.Ltime|log.Lmicroseconds)
+		// Create a logger for info messages.
+		l.InfoLogger = log.New(infoWriter, "INFO: ", log.Ldate|log.Ltime|log.Lmicroseconds)
 
-	Logger = &CombinedLogger{InfoLogger, ErrorLogger, verbosity}
+		// Create a logger for error messages.
+		l.ErrorLogger = log.New(errorWriter, "ERROR: ", log.Ldate|log.Ltime|log.Lmicroseconds)
+	})
 }
 
 func (l *CombinedLogger) Info(message string, a ...interface{}) {
+	l.open()
 	if l.verbosity >= 1 && l.InfoLogger != nil {
 		l.InfoLogger.Printf(l.formatLogMessage(message), a...)
 	}
@@ -100,6 +112,7 @@ func Info(message string, a ...interface{}) {
 }
 
 func (l *CombinedLogger) Error(message string, a ...interface{}) {
+	l.open()
 	if l.verbosity >= 1 && l.ErrorLogger != nil {
 		l.ErrorLogger.Printf(l.formatLogMessage(message), a...)
 	}
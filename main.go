@@ -6,7 +6,10 @@ import (
 	"chord/config"
 	"chord/node"
 	st "chord/storage"
+	"chord/tools"
 	"fmt"
+	"net"
+	"os"
 	"time"
 )
 
@@ -25,7 +28,10 @@ func main() {
 	// including create or join the ring
 	// and start the server
 	// and start the periodic tasks
-	chordNode.Initialize(config.NodeConfig.Mode, config.NodeConfig.JoinAddress, config.NodeConfig.JoinPort)
+	if err := chordNode.Initialize(config.NodeConfig.Mode); err != nil {
+		fmt.Printf("Initialize the node failed: %v\n", err)
+		os.Exit(1)
+	}
 
 	// stage 4: start the command line interface
 	// read commands from stdin and execute them
@@ -38,7 +44,39 @@ func NewNodeWithConfig(
 	storageFactory func(string) (st.Storage, error),
 ) (*node.Node, error) {
 	// first set the IdentifierLength, you have to set it first
-	identifierLength := 10 // identifier length (m)
+	identifierLength := cfg.IdentifierLength // identifier length (m)
+
+	// the identifier: pinned by -i, or assigned by the policy
+	options, err := identifierOptions(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error assigning identifier: %w", err)
+	}
+
+	lookupMode, err := node.ParseLookupMode(cfg.LookupMode)
+	if err != nil {
+		return nil, fmt.Errorf("error choosing lookup mode: %w", err)
+	}
+	options = append(options, node.WithHash(cfg.Hash))
+	options = append(options, node.WithLookupMode(lookupMode))
+	options = append(options, node.WithVirtualNodes(cfg.VirtualNodes))
+	options = append(options, node.WithFingersPerTick(cfg.FingersPerTick))
+	options = append(options, node.WithPredecessorsLength(cfg.Predecessors))
+	options = append(options, node.WithSuspicion(cfg.PhiThreshold, cfg.SuspicionFailures))
+	if cfg.AESBool {
+		options = append(options, node.WithFeatures(node.FeatureEncryption))
+	}
+	if cfg.AdaptiveIntervals {
+		options = append(options, node.WithAdaptiveIntervals())
+	}
+
+	// the bootstrap peers, already validated by the config
+	var bootstrapPeers node.NodeInfoList
+	for _, seed := range cfg.Seeds {
+		host, port, _ := net.SplitHostPort(seed)
+		bootstrapPeers = append(bootstrapPeers, node.NewNodeInfoWithAddress(host, port))
+	}
+	options = append(options, node.WithBootstrapPeers(bootstrapPeers))
+	options = append(options, node.WithJoinDeadline(time.Duration(cfg.JoinDeadline)*time.Second))
 
 	// then the path of the storage
 	storageDir := "storage" // storage directory
@@ -58,6 +96,7 @@ func NewNodeWithConfig(
 		cfg.TLSBool,
 		cfg.ServerTLSConfig,
 		cfg.ClientTLSConfig,
+		options...,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("error creating node: %w", err)
@@ -65,3 +104,20 @@ func NewNodeWithConfig(
 
 	return chordNode, nil
 }
+
+// identifierOptions converts the identifier settings into node options.
+// The -i identifier overrides the policy.
+func identifierOptions(cfg *config.Config) ([]node.Option, error) {
+	if cfg.Identifier != config.Unspecified {
+		identifier, err := tools.HexStringToBigInt(cfg.Identifier)
+		if err != nil {
+			return nil, err
+		}
+		return []node.Option{node.WithIdentifier(identifier)}, nil
+	}
+	idPolicy, err := node.ParseIdPolicy(cfg.IdPolicy)
+	if err != nil {
+		return nil, err
+	}
+	return []node.Option{node.WithIdPolicy(idPolicy)}, nil
+}
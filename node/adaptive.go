@@ -0,0 +1,95 @@
+package node
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	adaptiveFloorDivisor   = 4   // an adaptive interval shrinks down to its configured value / adaptiveFloorDivisor
+	adaptiveCeilingFactor  = 8   // and grows up to its configured value * adaptiveCeilingFactor
+	adaptiveJitterFraction = 0.1 // each wait is the interval +/- 10%, so the nodes don't synchronize
+)
+
+// adaptiveInterval is the interval of a periodic task.
+// In the adaptive mode it is halved on churn (a successor or predecessor change, or a failed RPC),
+// and grows by a quarter after each quiet run, between a floor and a ceiling around the configured value.
+// Otherwise it is the configured value.
+type adaptiveInterval struct {
+	mu       sync.Mutex
+	adaptive bool
+	floor    time.Duration
+	ceiling  time.Duration
+	current  time.Duration
+	churn    bool    // churn is observed since the last run
+	random   *random // draws the jitter
+}
+
+func newAdaptiveInterval(configured time.Duration, adaptive bool, random *random) *adaptiveInterval {
+	return &adaptiveInterval{
+		random:   random,
+		adaptive: adaptive,
+		floor:    max(configured/adaptiveFloorDivisor, time.Millisecond),
+		ceiling:  configured * adaptiveCeilingFactor,
+		current:  configured,
+	}
+}
+
+// next returns the wait before the next run of the task, it grows the interval if the last run was quiet.
+func (interval *adaptiveInterval) next() time.Duration {
+	interval.mu.Lock()
+	defer interval.mu.Unlock()
+	if !interval.adaptive {
+		return interval.current
+	}
+	if !interval.churn {
+		interval.current = min(interval.current+interval.current/4, interval.ceiling)
+	}
+	interval.churn = false
+	jitter := (interval.random.Float64()*2 - 1) * adaptiveJitterFraction * float64(interval.current)
+	return interval.current + time.Duration(jitter)
+}
+
+// shrink halves the interval, the churn has to be handled quickly.
+func (interval *adaptiveInterval) shrink() {
+	interval.mu.Lock()
+	defer interval.mu.Unlock()
+	if !interval.adaptive {
+		return
+	}
+	interval.churn = true
+	interval.current = max(interval.current/2, interval.floor)
+}
+
+// get returns the current interval, without jitter.
+func (interval *adaptiveInterval) get() time.Duration {
+	interval.mu.Lock()
+	defer interval.mu.Unlock()
+	return interval.current
+}
+
+// observeChurn shrinks the intervals of the periodic tasks.
+func (node *Node) observeChurn() {
+	node.stabilizeInterval.shrink()
+	node.fixFingersInterval.shrink()
+	node.checkPredecessorInterval.shrink()
+}
+
+// intervalsString formats the effective intervals of the periodic tasks.
+func (node *Node) intervalsString() string {
+	mode := "fixed"
+	if node.adaptiveIntervals {
+		mode = "adaptive"
+	}
+	return fmt.Sprintf("stabilize %v, fix fingers %v, check predecessor %v (%s)",
+		node.stabilizeInterval.get(), node.fixFingersInterval.get(), node.checkPredecessorInterval.get(), mode)
+}
+
+// sameNode checks if the two entries are the same node, two empty entries are the same.
+func sameNode(a, b *NodeInfo) bool {
+	if a.Empty() || b.Empty() {
+		return a.Empty() && b.Empty()
+	}
+	return a.Identifier.Cmp(b.Identifier) == 0 && a.IpAddress == b.IpAddress && a.Port == b.Port
+}
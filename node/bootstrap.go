@@ -0,0 +1,63 @@
+package node
+
+import (
+	"chord/log"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultJoinDeadline = 60 * time.Second       // how long the bootstrap peers are tried before giving up
+	joinBackoffMin      = 200 * time.Millisecond // the wait after the first round of failed joins
+	joinBackoffMax      = 10 * time.Second       // the backoff doubles after each round, up to this
+)
+
+// joinBootstrap tries the bootstrap peers in random order, round after round, until the node joins the ring.
+// Between two rounds it waits with an exponential backoff and jitter, so the new nodes don't hammer the seeds together.
+// It gives up when the join deadline is reached, or at once if the ring is not compatible with the node.
+func (node *Node) joinBootstrap() error {
+	defer log.LogFunction()()
+
+	if len(node.bootstrapPeers) == 0 {
+		return fmt.Errorf("no bootstrap peer to join through")
+	}
+
+	ctx, cancel := context.WithTimeout(node.ctx, node.joinDeadline)
+	defer cancel()
+
+	backoff := joinBackoffMin
+	var lastErr error
+	for round := 1; ; round++ {
+		peers := append(NodeInfoList{}, node.bootstrapPeers...)
+		node.random.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+		for _, peer := range peers {
+			err := node.joinRing(ctx, peer)
+			if err == nil {
+				log.Info("Joined through %s:%s in round %d", peer.IpAddress, peer.Port, round)
+				return nil
+			}
+			if errors.Is(err, errNotCompatible) {
+				return err
+			}
+			log.Error("Join through %s:%s failed, error: %v", peer.IpAddress, peer.Port, err)
+			fmt.Printf("Join through %s:%s failed, error: %v\n", peer.IpAddress, peer.Port, err)
+			lastErr = err
+		}
+		if node.manualScheduling {
+			// the driver owns the clock, it retries when it sees fit
+			return fmt.Errorf("join Chord Ring failed through all %d bootstrap peers: %w", len(peers), lastErr)
+		}
+
+		// full jitter: wait a random time in [backoff/2, backoff)
+		wait := backoff/2 + time.Duration(node.random.Int63n(int64(backoff/2)))
+		fmt.Printf("All %d bootstrap peers failed in round %d, retry in %v\n", len(peers), round, wait.Round(time.Millisecond))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return fmt.Errorf("give up joining after %v: %w", node.joinDeadline, lastErr)
+		}
+		backoff = min(backoff*2, joinBackoffMax)
+	}
+}
@@ -0,0 +1,16 @@
+package node
+
+import "time"
+
+// Clock tells the time to the failure detector of the node and times its calls,
+// a simulation replaces it with its virtual clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the real time.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
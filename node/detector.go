@@ -0,0 +1,120 @@
+package node
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPhiThreshold      = 8.0                   // the suspicion level at which a peer is declared dead, phi 8 means a 1e-8 chance of a mistake
+	defaultSuspicionFailures = 3                     // the number of failed calls in a row needed to declare a peer dead
+	heartbeatWindow          = 100                   // the number of inter-arrival times kept per peer
+	minHeartbeatGap          = 10 * time.Millisecond // the answers closer than this are one heartbeat, a burst of calls is not a rhythm
+	minStdDeviation          = 50 * time.Millisecond // the floor of the deviation, very regular heartbeats must not make phi jumpy
+)
+
+// peerHistory is what the failure detector knows about one peer.
+type peerHistory struct {
+	intervals []time.Duration // the last inter-arrival times of the heartbeats
+	last      time.Time       // the last heartbeat
+	failures  int             // the failed calls in a row since the last heartbeat
+}
+
+// failureDetector is a phi-accrual failure detector (Hayashibara et al.), fed by every call to the peers:
+// an answer is a heartbeat, the pings of the periodic tasks keep them regular, and a failed call is a failure.
+// Phi is the suspicion level, -log10 of the chance that the peer answers later than the time since its last heartbeat,
+// assuming normally distributed inter-arrival times. A peer is dead when phi crosses the threshold
+// and enough calls failed in a row, so one lost packet doesn't promote a new successor.
+// It lives in the host, as the virtual nodes of a peer live and die together.
+type failureDetector struct {
+	mu    sync.Mutex
+	peers map[string]*peerHistory // keyed by the address
+}
+
+func newFailureDetector() *failureDetector {
+	return &failureDetector{peers: make(map[string]*peerHistory)}
+}
+
+// heartbeat records an answer of the peer.
+func (detector *failureDetector) heartbeat(address string, now time.Time) {
+	detector.mu.Lock()
+	defer detector.mu.Unlock()
+	history, ok := detector.peers[address]
+	if !ok {
+		detector.peers[address] = &peerHistory{last: now}
+		return
+	}
+	history.failures = 0
+	if interval := now.Sub(history.last); interval >= minHeartbeatGap {
+		history.intervals = append(history.intervals, interval)
+		if len(history.intervals) > heartbeatWindow {
+			history.intervals = history.intervals[1:]
+		}
+	}
+	history.last = now
+}
+
+// failure records a failed call to the peer.
+func (detector *failureDetector) failure(address string) {
+	detector.mu.Lock()
+	defer detector.mu.Unlock()
+	if history, ok := detector.peers[address]; ok {
+		history.failures++
+	}
+}
+
+// forget drops the history of the peer, e.g. when it leaves the ring, so the next failed call declares it dead.
+func (detector *failureDetector) forget(address string) {
+	detector.mu.Lock()
+	defer detector.mu.Unlock()
+	delete(detector.peers, address)
+}
+
+// phi returns the suspicion level of the peer, 0 if it doesn't have enough heartbeats to tell.
+func (detector *failureDetector) phi(address string, now time.Time) float64 {
+	detector.mu.Lock()
+	defer detector.mu.Unlock()
+	history, ok := detector.peers[address]
+	if !ok || len(history.intervals) < 2 {
+		return 0
+	}
+	return history.phi(now)
+}
+
+func (history *peerHistory) phi(now time.Time) float64 {
+	var sum float64
+	for _, interval := range history.intervals {
+		sum += float64(interval)
+	}
+	mean := sum / float64(len(history.intervals))
+	var variance float64
+	for _, interval := range history.intervals {
+		variance += (float64(interval) - mean) * (float64(interval) - mean)
+	}
+	deviation := math.Max(math.Sqrt(variance/float64(len(history.intervals))), float64(minStdDeviation))
+
+	// the chance that the next heartbeat comes even later, the tail of the normal distribution
+	y := (float64(now.Sub(history.last)) - mean) / deviation
+	later := 0.5 * math.Erfc(y/math.Sqrt2)
+	return -math.Log10(later) // +Inf when later underflows to 0
+}
+
+// dead tells if the peer is declared dead, with the phi threshold and the number of failures in a row needed.
+// A peer that never answered has no history, it is dead as soon as a call fails.
+// A peer without enough heartbeats for phi is judged by its failures in a row only.
+func (detector *failureDetector) dead(address string, now time.Time, phiThreshold float64, failures int) bool {
+	detector.mu.Lock()
+	defer detector.mu.Unlock()
+	history, ok := detector.peers[address]
+	if !ok {
+		return true
+	}
+	if history.failures == 0 {
+		return false
+	}
+	if history.failures < failures {
+		return false
+	}
+	return len(history.intervals) < 2 || history.phi(now) >= phiThreshold
+}
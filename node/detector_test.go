@@ -0,0 +1,48 @@
+package node
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFailureDetector(t *testing.T) {
+	const address = "127.0.0.1:4170"
+	detector := newFailureDetector()
+	start := time.Now()
+
+	if !detector.dead(address, start, defaultPhiThreshold, defaultSuspicionFailures) {
+		t.Fatal("A peer that never answered must be dead")
+	}
+
+	// regular heartbeats every 100ms
+	now := start
+	for i := 0; i < 20; i++ {
+		now = start.Add(time.Duration(i) * 100 * time.Millisecond)
+		detector.heartbeat(address, now)
+	}
+	if phi := detector.phi(address, now.Add(100*time.Millisecond)); phi > 1 {
+		t.Fatalf("phi is %f one interval after the last heartbeat, want a low suspicion", phi)
+	}
+
+	// one lost packet is not enough
+	detector.failure(address)
+	if detector.dead(address, now.Add(2*time.Second), defaultPhiThreshold, defaultSuspicionFailures) {
+		t.Fatal("A peer is dead after one failure")
+	}
+
+	// failures in a row, but the last heartbeat is recent
+	detector.failure(address)
+	detector.failure(address)
+	if detector.dead(address, now.Add(150*time.Millisecond), defaultPhiThreshold, defaultSuspicionFailures) {
+		t.Fatal("A peer is dead while phi is still low")
+	}
+	if !detector.dead(address, now.Add(2*time.Second), defaultPhiThreshold, defaultSuspicionFailures) {
+		t.Fatalf("A silent peer with %d failures is not dead, phi is %f", defaultSuspicionFailures, detector.phi(address, now.Add(2*time.Second)))
+	}
+
+	// an answer clears the suspicion
+	detector.heartbeat(address, now.Add(2*time.Second))
+	if detector.dead(address, now.Add(2*time.Second), defaultPhiThreshold, defaultSuspicionFailures) {
+		t.Fatal("A peer that answered again is still dead")
+	}
+}
@@ -3,29 +3,50 @@ package node
 import (
 	"chord/log"
 	"chord/tools"
+	"context"
 	"fmt"
 	"math/big"
 )
 
-// maxSteps variable, used in findSuccessorIter (find_successor).
-const maxSteps = 10
-
 // Iterative implementation of the find_successor function, used as an entrance.
-// Asks the node (nodeInfo) to FindSuccessorIter the successor of the identifier.
+// Asks the remote node to FindSuccessorIter the successor of the identifier.
 // Theoretically speaking, this function will not fail.
 // But in practice, it may fail due to the network or other reasons.
 //  1. return (empty NodeInfo, handleCall error) if handleCall (its warp) failed.
-//  2. return (empty NodeInfo, custom error) if the successor is not found within maxSteps steps.
+//  2. return (empty NodeInfo, custom error) if the successor is not found within maxSteps steps,
+//     about 2·log2 N from the ring size estimated by the local node, see maxLookupSteps.
 //  3. return (found NodeInfo, nil) if the successor is found.
-func (nodeInfo *NodeInfo) FindSuccessorIter(identifier *big.Int) (*NodeInfo, error) {
+func (remote *RemoteNode) FindSuccessorIter(identifier *big.Int) (*NodeInfo, error) {
+	return remote.FindSuccessorIterContext(context.Background(), identifier)
+}
+
+// FindSuccessorIterContext is FindSuccessorIter with a context, the lookup stops when ctx is done.
+func (remote *RemoteNode) FindSuccessorIterContext(ctx context.Context, identifier *big.Int) (*NodeInfo, error) {
+	return remote.findSuccessorIter(ctx, identifier, nil)
+}
+
+// FindSuccessorIterTrace is FindSuccessorIter, but it also returns the trace of the lookup,
+// with each hop, where its answer comes from, its RTT and its error.
+// The trace is returned even if the lookup fails, that's when it is the most useful.
+func (remote *RemoteNode) FindSuccessorIterTrace(ctx context.Context, identifier *big.Int) (*NodeInfo, *LookupTrace, error) {
+	trace := &LookupTrace{Identifier: identifier}
+	nodeInfo, err := remote.findSuccessorIter(ctx, identifier, trace)
+	return nodeInfo, trace, err
+}
+
+// findSuccessorIter is the implementation of FindSuccessorIter, each hop is recorded in the trace if it is not nil.
+func (remote *RemoteNode) findSuccessorIter(ctx context.Context, identifier *big.Int, trace *LookupTrace) (*NodeInfo, error) {
 	defer log.LogFunction()()
 
 	found := false
-	nextNode := nodeInfo // start from itself
+	nextNode := remote.info // start from itself
+	maxSteps := remote.local.maxLookupSteps()
 
 	for i := 0; !found && i < maxSteps; i++ {
 		log.Info("Step %d: Execute %v.find_successor(%v)", i, nextNode, identifier)
-		reply, err := nextNode.FindSuccessor(identifier)
+		start := remote.local.clock.Now()
+		reply, err := remote.local.Remote(nextNode).FindSuccessorContext(ctx, identifier)
+		trace.record(i, nextNode, reply, remote.local.clock.Now().Sub(start), err)
 		if err != nil {
 			log.Error("%v.FindSuccessor(%v) failed", nextNode, identifier)
 			return nil, err
@@ -39,54 +60,157 @@ func (nodeInfo *NodeInfo) FindSuccessorIter(identifier *big.Int) (*NodeInfo, err
 		return nextNode, nil
 	} else {
 		log.Info("maxSteps reached, nextNode now is %v, but the successor is not found", nextNode)
-		return nil, fmt.Errorf("failed to findSuccessorIter the successor within maxSteps")
+		return nil, fmt.Errorf("failed to findSuccessorIter the successor within %d steps", maxSteps)
+	}
+}
+
+// LookupMode decides how a lookup walks the ring.
+type LookupMode string
+
+const (
+	LookupIterative LookupMode = "iterative" // the originator contacts every hop itself
+	LookupRecursive LookupMode = "recursive" // each hop forwards the request to the next one
+)
+
+// ParseLookupMode converts the string to a LookupMode.
+func ParseLookupMode(str string) (LookupMode, error) {
+	switch LookupMode(str) {
+	case LookupIterative, LookupRecursive:
+		return LookupMode(str), nil
+	default:
+		return "", fmt.Errorf("unknown lookup mode %q", str)
+	}
+}
+
+// Lookup asks the remote node to find the successor of the identifier,
+// using the lookup mode of the local node.
+// It is the entrance for all the lookups (cmd, fixFingers, join).
+func (remote *RemoteNode) Lookup(ctx context.Context, identifier *big.Int) (*NodeInfo, error) {
+	// a peer that doesn't serve recursive lookups is asked iteratively
+	if remote.local.lookupMode == LookupRecursive && remote.supports(ctx, FeatureRecursiveLookup) {
+		return remote.FindSuccessorRecursive(ctx, identifier)
+	}
+	return remote.FindSuccessorIterContext(ctx, identifier)
+}
+
+// findSuccessorRecursive is the recursive implementation of the find_successor function.
+// If the successor is not known locally, the request is forwarded to the closest preceding node,
+// and the answer comes back along the chain.
+// hops is the number of hops left, so a broken ring can't forward the request forever.
+// It runs on the RPC handler side, so the forwarded call is bounded by the node's own context.
+func (node *Node) findSuccessorRecursive(identifier *big.Int, hops int) (*NodeInfo, error) {
+	defer log.LogFunction()()
+
+	found, nextNode := node.FindSuccessor(identifier)
+	if found {
+		log.Info("Successor is found: %v", nextNode)
+		return nextNode, nil
+	}
+	if nextNode.Identifier.Cmp(node.info.Identifier) == 0 {
+		// forwarding to itself would never end
+		return nil, fmt.Errorf("%v can't get closer to %v", node.info, identifier)
+	}
+	if hops <= 1 {
+		log.Info("maxSteps reached, nextNode now is %v, but the successor is not found", nextNode)
+		return nil, fmt.Errorf("failed to findSuccessorRecursive the successor within maxSteps")
+	}
+
+	if !node.Remote(nextNode).supports(node.ctx, FeatureRecursiveLookup) {
+		log.Info("%v doesn't serve recursive lookups, go on iteratively from it", nextNode)
+		return node.Remote(nextNode).FindSuccessorIterContext(node.ctx, identifier)
 	}
+	log.Info("Forward find_successor(%v) to %v, %d hops left", identifier, nextNode, hops-1)
+	return node.Remote(nextNode).findSuccessorRecursive(node.ctx, identifier, hops-1)
 }
 
 // FindSuccessor : asks the node to find the successor of the identifier
 func (node *Node) FindSuccessor(identifier *big.Int) (bool, *NodeInfo) {
+	found, nodeInfo, _ := node.findSuccessor(identifier)
+	return found, nodeInfo
+}
+
+// findSuccessor is FindSuccessor, but it also tells where the answer comes from.
+func (node *Node) findSuccessor(identifier *big.Int) (bool, *NodeInfo, RouteSource) {
 	log.Info("%v.find_successor(%v)", node.info, identifier)
 	// id is in (n, successor)
 	successor := node.GetFirstSuccessor()
 	if tools.ModIntervalCheck(identifier, node.info.Identifier, successor.Identifier, false, true) {
 		log.Info("%s is in (%v, %v], find the successor!", identifier, node.info, successor)
-		return true, successor
+		return true, successor, RouteSuccessor
+	} else if owner := node.predecessorOwner(identifier); owner == &node.info {
+		// the identifier is in (predecessor, n]
+		log.Info("%s is owned by the node itself, found with the predecessor", identifier)
+		return true, owner, RoutePredecessorList
+	} else if owner != nil && node.Remote(owner).LiveCheckContext(node.ctx) == nil {
+		// the identifier is behind the node, going counter-clockwise is shorter than around the ring
+		// the list is only refreshed by notify, a node may have joined in front of the owner since, so the owner confirms it
+		log.Info("%s is owned by %v according to the predecessor list, ask it", identifier, owner)
+		return false, owner, RoutePredecessorList
 	} else {
 		log.Info("%v is not in (%v, %v], go to %v.closestPrecedingNode(%v)", identifier, node.info, successor, node.info, identifier)
-		return false, node.closestPrecedingNode(identifier)
+		nodeInfo, source := node.closestPrecedingNode(identifier)
+		return false, nodeInfo, source
+	}
+}
+
+// predecessorOwner returns the owner of the identifier if it falls in the range covered by the predecessor list:
+// the node itself for (predecessors[0], n], and predecessors[i] for (predecessors[i+1], predecessors[i]].
+// It returns nil otherwise. Apart from the node itself, the owner is only a guess, see findSuccessor.
+func (node *Node) predecessorOwner(identifier *big.Int) *NodeInfo {
+	owner := &node.info
+	for _, predecessor := range node.GetPredecessors() {
+		if predecessor.Empty() || predecessor.Identifier.Cmp(node.info.Identifier) == 0 {
+			return nil
+		}
+		if tools.ModIntervalCheck(identifier, predecessor.Identifier, owner.Identifier, false, true) {
+			return owner
+		}
+		owner = predecessor
 	}
+	return nil
 }
 
 // Search the local table for highest predecessor of the identifier.
-func (node *Node) closestPrecedingNode(identifier *big.Int) *NodeInfo {
+// It also returns where the entry comes from, the finger table or the successor list of the finger.
+func (node *Node) closestPrecedingNode(identifier *big.Int) (*NodeInfo, RouteSource) {
 	defer log.LogFunction()()
 
 	// first search in the local finger table
 	log.Info("Search in the local finger table")
 	fingerEntry := node.findNearestNodeInFingers(identifier)
 	log.Info("The fingerEntry is %v", fingerEntry)
+	fingerSource := RouteFinger
+	if fingerEntry == &node.info {
+		fingerSource = RouteSelf
+	}
 
 	// also search the successor list for the most immediate predecessor of id, which is the fingerEntry
-	successors, err := fingerEntry.GetSuccessors()
+	successors, err := node.Remote(fingerEntry).GetSuccessorsContext(node.ctx)
 	if err != nil {
 		log.Error("Failed to get the fingerEntry's successors")
-		return fingerEntry
+		return fingerEntry, fingerSource
 	}
 
 	// then search in the fingerEntry's successors
 	log.Info("Search in the fingerEntry's successors")
 	successorEntry := fingerEntry.findNearestNode(identifier, successors)
 	log.Info("The successorEntry is %v", successorEntry)
+	if successorEntry == fingerEntry {
+		return fingerEntry, fingerSource
+	}
 
-	return successorEntry
+	return successorEntry, RouteSuccessorList
 }
 
 // Specially designed for the finger table, to ensure we read one of them a time.
 // For simplicity, you may choose to read all of them and them process them.
+// Among the proximityCandidates fingers making the most progress, the one with the lowest RTT is chosen,
+// so the lookups don't zig-zag across far away nodes when a close one is almost as good.
 func (node *Node) findNearestNodeInFingers(identifier *big.Int) *NodeInfo {
 	defer log.LogFunction()()
 
-	for i := node.identifierLength - 1; i >= 0; i-- {
+	var candidates NodeInfoList
+	for i := node.identifierLength - 1; i >= 0 && len(candidates) < proximityCandidates; i-- {
 		finger := node.GetFingerEntry(i)
 		if finger.Empty() {
 			log.Info("finger[%d] is empty", i)
@@ -96,12 +220,20 @@ func (node *Node) findNearestNodeInFingers(identifier *big.Int) *NodeInfo {
 			log.Info("finger[%d]: %v is not in (%v, %v]", i, finger, node.info, identifier)
 			continue
 		}
+		if len(candidates) > 0 && candidates[len(candidates)-1].Identifier.Cmp(finger.Identifier) == 0 {
+			continue // adjacent fingers often point to the same node
+		}
 		// finger is in (n, id)
-		log.Info("finger[%d]: %v is in (%v, %v], return this entry", i, finger, node.info, identifier)
-		return finger
+		log.Info("finger[%d]: %v is in (%v, %v], it is a candidate", i, finger, node.info, identifier)
+		candidates = append(candidates, finger)
+	}
+	if len(candidates) == 0 {
+		log.Info("No nearest node found, return %v itself", node.info)
+		return &node.info
 	}
-	log.Info("No nearest node found, return %v itself", node.info)
-	return &node.info
+	finger := node.closestByRTT(candidates)
+	log.Info("Return %v among %d candidates", finger, len(candidates))
+	return finger
 }
 
 // Find the nearest node in the nodeList to the identifier.
@@ -129,18 +261,55 @@ func (nodeInfo *NodeInfo) findNearestNode(identifier *big.Int, nodeList NodeInfo
 /*                             RPC Part                             */
 
 // FindSuccessor a wrap of FindSuccessorRPC method.
-func (nodeInfo *NodeInfo) FindSuccessor(identifier *big.Int) (*FindSuccessorReply, error) {
+func (remote *RemoteNode) FindSuccessor(identifier *big.Int) (*FindSuccessorReply, error) {
+	return remote.FindSuccessorContext(context.Background(), identifier)
+}
+
+// FindSuccessorContext is FindSuccessor with a context, the call is abandoned when ctx is done.
+func (remote *RemoteNode) FindSuccessorContext(ctx context.Context, identifier *big.Int) (*FindSuccessorReply, error) {
 	reply := &FindSuccessorReply{}
-	err := nodeInfo.callRPC("FindSuccessorRPC", identifier, reply)
+	err := remote.callRPC(ctx, "FindSuccessorRPC", identifier, reply)
 	return reply, err
 }
 
 // FindSuccessorRPC : asks the node to findSuccessorIter the successor of the identifier
 func (handler *RPCHandler) FindSuccessorRPC(identifier *big.Int, reply *FindSuccessorReply) error {
 	defer log.LogFunction()()
-	found, nodeInfo := localNode.FindSuccessor(identifier)
+	found, nodeInfo, source := handler.node.findSuccessor(identifier)
 	reply.Found = found
 	reply.NodeInfo = *nodeInfo
+	reply.Source = source
+	return nil
+}
+
+// FindSuccessorRecursive asks the remote node to find the successor of the identifier recursively.
+// The remote node forwards the request hop by hop, and only the answer comes back.
+func (remote *RemoteNode) FindSuccessorRecursive(ctx context.Context, identifier *big.Int) (*NodeInfo, error) {
+	defer log.LogFunction()()
+	return remote.findSuccessorRecursive(ctx, identifier, remote.local.maxLookupSteps())
+}
+
+// findSuccessorRecursive a wrap of FindSuccessorRecursiveRPC method.
+func (remote *RemoteNode) findSuccessorRecursive(ctx context.Context, identifier *big.Int, hops int) (*NodeInfo, error) {
+	args := &FindSuccessorRecursiveArgs{
+		Identifier: identifier,
+		Hops:       hops,
+	}
+	reply := &NodeInfo{}
+	if err := remote.callRPC(ctx, "FindSuccessorRecursiveRPC", args, reply); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// FindSuccessorRecursiveRPC : asks the node to find the successor of the identifier, forwarding the request if needed
+func (handler *RPCHandler) FindSuccessorRecursiveRPC(args *FindSuccessorRecursiveArgs, reply *NodeInfo) error {
+	defer log.LogFunction()()
+	nodeInfo, err := handler.node.findSuccessorRecursive(args.Identifier, args.Hops)
+	if err != nil {
+		return err
+	}
+	*reply = *nodeInfo
 	return nil
 }
 
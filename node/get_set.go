@@ -6,17 +6,68 @@ func (node *Node) GetInfo() *NodeInfo {
 
 // We don't provide SetInfo method because the node's Info should not be changed after the node is created.
 
-// GetPredecessor : get the node's predecessor
+// GetState : get the node's ring state
+func (node *Node) GetState() RingState {
+	node.muState.RLock()
+	defer node.muState.RUnlock()
+	return node.state
+}
+
+func (node *Node) setState(state RingState) {
+	node.muState.Lock()
+	defer node.muState.Unlock()
+	node.state = state
+}
+
+// GetPredecessor : get the node's predecessor (index 0 of the predecessor list)
 func (node *Node) GetPredecessor() *NodeInfo {
 	node.muPre.RLock()
 	defer node.muPre.RUnlock()
-	return node.predecessor
+	return node.predecessors[0]
 }
 
+// SetPredecessor : set the node's predecessor
+// If the new predecessor is already on the predecessor list, the entries before it are dropped,
+// otherwise the rest of the list is unknown until the predecessor notifies the node.
 func (node *Node) SetPredecessor(predecessor *NodeInfo) {
 	node.muPre.Lock()
-	defer node.muPre.Unlock()
-	node.predecessor = predecessor
+	old := node.predecessors
+	changed := !sameNode(old[0], predecessor)
+	if changed {
+		predecessors := NodeInfoList{predecessor}
+		for i := range old {
+			if !predecessor.Empty() && sameNode(old[i], predecessor) {
+				predecessors = append(predecessors, old[i+1:]...)
+				break
+			}
+		}
+		for len(predecessors) < node.predecessorsLength {
+			predecessors = append(predecessors, NewNodeInfo())
+		}
+		node.predecessors = predecessors
+	}
+	node.muPre.Unlock()
+	if changed {
+		node.observeChurn()
+	}
+}
+
+// GetPredecessors : get the node's predecessors
+func (node *Node) GetPredecessors() NodeInfoList {
+	node.muPre.RLock()
+	defer node.muPre.RUnlock()
+	return node.predecessors
+}
+
+// SetPredecessors : set the node's predecessors, the list is replaced as a whole and never changed in place
+func (node *Node) SetPredecessors(predecessors NodeInfoList) {
+	node.muPre.Lock()
+	changed := !sameNode(node.predecessors[0], predecessors[0])
+	node.predecessors = predecessors
+	node.muPre.Unlock()
+	if changed {
+		node.observeChurn()
+	}
 }
 
 // GetSuccessors : get the node's successors
@@ -29,8 +80,17 @@ func (node *Node) GetSuccessors() NodeInfoList {
 // SetSuccessors : set the node's successors
 func (node *Node) SetSuccessors(successors NodeInfoList) {
 	node.muSuc.Lock()
-	defer node.muSuc.Unlock()
+	changed := false
+	for i := range successors {
+		if i >= len(node.successors) || !sameNode(node.successors[i], successors[i]) {
+			changed = true
+		}
+	}
 	node.successors = successors
+	node.muSuc.Unlock()
+	if changed {
+		node.observeChurn()
+	}
 }
 
 // GetSuccessor : get the node's successor by index
@@ -50,19 +110,24 @@ func (node *Node) GetFirstSuccessor() *NodeInfo {
 }
 
 // SetSuccessor : set the node's successor by index
+// The list is copied, GetSuccessors hands it out without the lock.
 func (node *Node) SetSuccessor(index int, successor *NodeInfo) {
 	node.muSuc.Lock()
-	defer node.muSuc.Unlock()
-	node.successors[index] = successor
+	changed := !sameNode(node.successors[index], successor)
+	successors := append(NodeInfoList{}, node.successors...)
+	successors[index] = successor
+	node.successors = successors
+	node.muSuc.Unlock()
+	if changed {
+		node.observeChurn()
+	}
 }
 
 // SetFirstSuccessor : set the first successor (index 0)
 // It is specially designed for the first successor to boost the performance.
 // As the first successor is the most frequently used one, we provide a special method for it.
 func (node *Node) SetFirstSuccessor(successor *NodeInfo) {
-	node.muSuc.Lock()
-	defer node.muSuc.Unlock()
-	node.successors[0] = successor
+	node.SetSuccessor(0, successor)
 }
 
 // GetFingerEntry : get the node's finger table entry
@@ -80,3 +145,4 @@ func (node *Node) SetFingerEntry(index int, fingerEntry *NodeInfo) {
 }
 
 // We don't provide GetFingertable or SetFingertable method because we won't get or set the whole finger table at once.
+// GetFingerTableRPC reads the entries one by one, for the ring check.
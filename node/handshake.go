@@ -0,0 +1,279 @@
+package node
+
+import (
+	"chord/log"
+	"chord/tools"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// ProtocolVersion is the version of the RPC protocol spoken by this build.
+	// Bump it when an RPC or an argument struct changes in a way the older nodes can't decode.
+	ProtocolVersion = 1
+	// MinProtocolVersion is the oldest version this build still talks to.
+	// Version 0 is the nodes before the handshake, they only answer GetLengthRPC and can't decode the new arguments,
+	// so they are refused: a ring of version 0 nodes is upgraded by restarting all of them, not node by node.
+	// From version 1 on, the optional features are negotiated, a ring can be upgraded node by node as long as
+	// MinProtocolVersion isn't raised above the version of the nodes still running.
+	MinProtocolVersion = 1
+)
+
+// BuildVersion is the version of the binary, set at build time with
+// go build -ldflags "-X chord/node.BuildVersion=v1.2.3".
+var BuildVersion = "dev"
+
+// Feature is an optional capability of a node, announced in the handshake.
+type Feature string
+
+const (
+	FeatureTLS             Feature = "tls"          // the node only talks TLS
+	FeatureEncryption      Feature = "encryption"   // the files are AES encrypted by the clients of the node
+	FeatureCompression     Feature = "compression"  // the node understands compressed file contents, announced with WithFeatures
+	FeatureRecursiveLookup Feature = "recursive"    // the node serves FindSuccessorRecursiveRPC
+	FeatureSecureIds       Feature = "secureid"     // the identifiers are derived from the TLS keys, see IdPolicySecure
+	FeaturePredecessorList Feature = "predecessors" // the node serves NotifyListRPC, and keeps a predecessor list
+)
+
+// Hello is what a node tells about itself in the handshake, it is both the args and the reply of HelloRPC.
+type Hello struct {
+	ProtocolVersion    int
+	MinProtocolVersion int
+	BuildVersion       string
+	Features           []Feature
+
+	IdentifierLength int
+	SuccessorsLength int
+	Hash             string   // the hash the identifiers are cut from, empty for the nodes from before the choice
+	Info             NodeInfo // the identity that says hello
+	VirtualNodes     int      // the number of identities of the host, with IdPolicySecure they are all derived from its key
+}
+
+// Session is the outcome of a handshake with a peer: the protocol version both sides speak,
+// and the optional features both sides support.
+type Session struct {
+	ProtocolVersion int
+	Features        []Feature
+	Peer            Hello
+	Outgoing        bool   // the node made the handshake, so the Peer answered at the address, see outgoingSession
+	Key             []byte // the public key the peer presented on the connection of an outgoing handshake, nil without TLS
+}
+
+// Supports tells if both sides of the session support the feature.
+func (session *Session) Supports(feature Feature) bool {
+	return slices.Contains(session.Features, feature)
+}
+
+func (session *Session) String() string {
+	features := make([]string, len(session.Features))
+	for i, feature := range session.Features {
+		features[i] = string(feature)
+	}
+	return fmt.Sprintf("protocol %d, build %s, features [%s]",
+		session.ProtocolVersion, session.Peer.BuildVersion, strings.Join(features, " "))
+}
+
+// sessionTable keeps the session of each peer address, negotiated once per connection.
+// The session of a peer is dropped when a call to it fails, it may come back with another build.
+type sessionTable struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+func newSessionTable() *sessionTable {
+	return &sessionTable{sessions: make(map[string]*Session)}
+}
+
+func (table *sessionTable) get(address string) *Session {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	return table.sessions[address]
+}
+
+func (table *sessionTable) put(address string, session *Session) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	table.sessions[address] = session
+}
+
+func (table *sessionTable) forget(address string) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	delete(table.sessions, address)
+}
+
+// addresses returns the addresses with a session, sorted.
+func (table *sessionTable) addresses() []string {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	addresses := make([]string, 0, len(table.sessions))
+	for address := range table.sessions {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// features returns the optional features of the node.
+func (node *Node) features() []Feature {
+	features := []Feature{FeatureRecursiveLookup, FeaturePredecessorList}
+	if node.tlsBool {
+		features = append(features, FeatureTLS)
+	}
+	if node.secureIdentifiers() {
+		features = append(features, FeatureSecureIds)
+	}
+	for _, feature := range node.extraFeatures {
+		if !slices.Contains(features, feature) {
+			features = append(features, feature)
+		}
+	}
+	return features
+}
+
+// hello returns the Hello of the node.
+func (node *Node) hello() Hello {
+	return Hello{
+		ProtocolVersion:    ProtocolVersion,
+		MinProtocolVersion: MinProtocolVersion,
+		BuildVersion:       BuildVersion,
+		Features:           node.features(),
+		IdentifierLength:   node.identifierLength,
+		SuccessorsLength:   node.successorsLength,
+		Hash:               node.hashName,
+		Info:               node.info,
+		VirtualNodes:       node.virtualCount,
+	}
+}
+
+// negotiate checks that the peer is compatible with the node, and returns their session.
+//  1. the protocol versions must overlap, the session uses the highest version both speak.
+//  2. they must use the same identifier space (m) and the same number of successors (r).
+//  3. they must cut the identifiers from the same hash, or the same name would land on different nodes.
+//  4. the TLS setting must be the same on both sides.
+//  5. the secure identifiers too, a node checking the identifiers would reject all the others.
+//     With them, the peer must announce between 1 and 64 identities, the ones its key vouches for.
+//
+// The other features are optional, the session keeps those both sides support.
+func (node *Node) negotiate(peer *Hello) (*Session, error) {
+	if peer.ProtocolVersion < MinProtocolVersion {
+		return nil, fmt.Errorf("protocol version %d (build %s) is too old, this node (build %s) speaks %d to %d",
+			peer.ProtocolVersion, peer.BuildVersion, BuildVersion, MinProtocolVersion, ProtocolVersion)
+	}
+	if ProtocolVersion < peer.MinProtocolVersion {
+		return nil, fmt.Errorf("protocol version %d (build %s) is too old for the peer (build %s), it speaks %d to %d",
+			ProtocolVersion, BuildVersion, peer.BuildVersion, peer.MinProtocolVersion, peer.ProtocolVersion)
+	}
+	if err := node.checkLength(&GetLengthReply{IdentifierLength: peer.IdentifierLength, SuccessorsLength: peer.SuccessorsLength}); err != nil {
+		return nil, err
+	}
+	peerHash := peer.Hash
+	if peerHash == "" {
+		peerHash = tools.DefaultHash // the peer is older than the choice of the hash
+	}
+	if peerHash != node.hashName {
+		return nil, fmt.Errorf("the identifiers are cut from %s on this node, but from %s on the peer", node.hashName, peerHash)
+	}
+	if node.tlsBool != slices.Contains(peer.Features, FeatureTLS) {
+		return nil, fmt.Errorf("TLS is %t on this node, but not on the peer", node.tlsBool)
+	}
+	if node.secureIdentifiers() != slices.Contains(peer.Features, FeatureSecureIds) {
+		return nil, fmt.Errorf("secure identifiers are %t on this node, but not on the peer", node.secureIdentifiers())
+	}
+	if node.secureIdentifiers() && (peer.VirtualNodes < 1 || peer.VirtualNodes > maxVirtualIndex+1) {
+		return nil, fmt.Errorf("the peer announces %d identities, want 1 to %d", peer.VirtualNodes, maxVirtualIndex+1)
+	}
+
+	session := &Session{ProtocolVersion: min(ProtocolVersion, peer.ProtocolVersion), Peer: *peer}
+	for _, feature := range node.features() {
+		if slices.Contains(peer.Features, feature) {
+			session.Features = append(session.Features, feature)
+		}
+	}
+	return session, nil
+}
+
+// Session returns the session with the remote node, making the handshake if there is none yet.
+func (remote *RemoteNode) Session(ctx context.Context) (*Session, error) {
+	if session := remote.local.host.sessions.get(remote.address()); session != nil {
+		return session, nil
+	}
+	return remote.Handshake(ctx)
+}
+
+// supports tells if both sides support the feature, false if the handshake fails.
+func (remote *RemoteNode) supports(ctx context.Context, feature Feature) bool {
+	session, err := remote.Session(ctx)
+	if err != nil {
+		log.Error("Handshake with %v failed: %v", remote.info, err)
+		return false
+	}
+	return session.Supports(feature)
+}
+
+// Handshake says hello to the remote node and negotiates their session, which replaces the previous one.
+// It fails with errNotCompatible if one of the sides refuses the other,
+// or if the peer predates the handshake: it speaks protocol version 0, older than MinProtocolVersion.
+func (remote *RemoteNode) Handshake(ctx context.Context) (*Session, error) {
+	// the key of the peer is kept in the session, so the identities it answers for are known without another call
+	var key []byte
+	check := remote.peerCheck(ctx)
+	record := func(peerKey []byte) error {
+		key = peerKey
+		if check != nil {
+			return check(peerKey)
+		}
+		return nil
+	}
+	hello, peer := remote.local.hello(), &Hello{}
+	err := remote.callRPCWithCheck(ctx, "HelloRPC", &hello, peer, remote.local.callTimeout, record)
+	switch {
+	case err != nil && isServerError(err) && strings.Contains(err.Error(), "can't find method"):
+		return nil, fmt.Errorf("%v is %w: it predates the handshake (protocol version 0), this node (build %s) speaks %d to %d",
+			remote.info, errNotCompatible, BuildVersion, MinProtocolVersion, ProtocolVersion)
+	case err != nil && isServerError(err):
+		return nil, fmt.Errorf("%v refused the handshake, it is %w: %v", remote.info, errNotCompatible, err)
+	case err != nil:
+		return nil, err
+	}
+
+	session, err := remote.local.negotiate(peer)
+	if err != nil {
+		return nil, fmt.Errorf("%v is %w: %v", remote.info, errNotCompatible, err)
+	}
+	session.Outgoing = true
+	session.Key = key
+	remote.local.host.sessions.put(remote.address(), session)
+	return session, nil
+}
+
+/*                             RPC Part                             */
+
+// Hello A wrap of HelloRPC method, it sends the hello of the local node and returns the hello of the remote node.
+func (remote *RemoteNode) Hello(hello Hello) (*Hello, error) {
+	return remote.HelloContext(context.Background(), hello)
+}
+
+// HelloContext is Hello with a context, the call is abandoned when ctx is done.
+func (remote *RemoteNode) HelloContext(ctx context.Context, hello Hello) (*Hello, error) {
+	reply := &Hello{}
+	err := remote.callRPC(ctx, "HelloRPC", &hello, reply)
+	return reply, err
+}
+
+// HelloRPC : answer the handshake of a peer with the hello of the node, or refuse an incompatible peer.
+// The session is kept for the calls back to the peer.
+func (handler *RPCHandler) HelloRPC(args *Hello, reply *Hello) error {
+	session, err := handler.node.negotiate(args)
+	if err != nil {
+		log.Error("Refused the handshake of %v: %v", args.Info, err)
+		return fmt.Errorf("refused by %v: %v", handler.node.info, err)
+	}
+	handler.node.host.sessions.put(args.Info.IpAddress+":"+args.Info.Port, session)
+	*reply = handler.node.hello()
+	return nil
+}
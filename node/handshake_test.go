@@ -0,0 +1,118 @@
+package node
+
+import (
+	cfs "chord/cachefilesystem"
+	"chord/tools"
+	"errors"
+	"net/rpc"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHandshake(t *testing.T) {
+	network := NewMemoryNetwork(1)
+	seed := startTestNode(t, network, "4170", nil)
+
+	newPeer := func(port string, successorsLength int, options ...Option) *Node {
+		dir := t.TempDir()
+		options = append(options, WithTransport(network.Transport("127.0.0.1:"+port)))
+		node, err := NewNode(10, successorsLength, "127.0.0.1", port, cfs.CacheStorageFactory,
+			filepath.Join(dir, "storage"), filepath.Join(dir, "backup"), 50, 20, 50, false, nil, nil, options...)
+		if err != nil {
+			t.Fatalf("Failed to create node %s: %v", port, err)
+		}
+		t.Cleanup(node.Close)
+		return node
+	}
+
+	// the optional features both sides support are kept
+	peer := newPeer("4171", 2, WithFeatures(FeatureCompression))
+	session, err := peer.Remote(&seed.info).Handshake(peer.ctx)
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	if session.ProtocolVersion != ProtocolVersion || !session.Supports(FeatureRecursiveLookup) || session.Supports(FeatureCompression) {
+		t.Fatalf("Session is %v, want protocol %d with recursive lookups only", session, ProtocolVersion)
+	}
+	if seed.host.sessions.get("127.0.0.1:4171") == nil {
+		t.Fatalf("The seed kept no session for the peer")
+	}
+
+	// a peer with another r is refused, and the join gives up at once
+	other := newPeer("4172", 3)
+	err = other.joinRing(other.ctx, NewNodeInfoWithAddress("127.0.0.1", "4170"))
+	if !errors.Is(err, errNotCompatible) {
+		t.Fatalf("Join with another r: got %v, want a %v error", err, errNotCompatible)
+	}
+
+	// a peer cutting its identifiers from another hash is refused too
+	sha256Peer := newPeer("4173", 2, WithHash(tools.HashSHA256))
+	err = sha256Peer.joinRing(sha256Peer.ctx, NewNodeInfoWithAddress("127.0.0.1", "4170"))
+	if !errors.Is(err, errNotCompatible) {
+		t.Fatalf("Join with another hash: got %v, want a %v error", err, errNotCompatible)
+	}
+	// the hash and m belong to each node, the peer doesn't change the keys of the seed
+	if got, want := seed.GenerateIdentifier("file"), tools.GenerateIdentifier("file", tools.DefaultHash, 10); got.Cmp(want) != 0 {
+		t.Fatalf("The seed cuts the key of a file to %v after the peer with another hash, want %v", got, want)
+	}
+	if identifier := sha256Peer.GenerateIdentifier("file"); identifier.Cmp(tools.GenerateIdentifier("file", tools.HashSHA256, 10)) != 0 {
+		t.Fatalf("The peer with another hash cuts the key of a file to %v, want the %s one", identifier, tools.HashSHA256)
+	}
+
+	// a peer that only speaks newer protocols is refused
+	hello := seed.hello()
+	hello.ProtocolVersion, hello.MinProtocolVersion = ProtocolVersion+2, ProtocolVersion+1
+	if _, err := seed.negotiate(&hello); err == nil {
+		t.Fatalf("Negotiation with protocol %d to %d succeeded", hello.MinProtocolVersion, hello.ProtocolVersion)
+	}
+}
+
+func TestLegacyNotify(t *testing.T) {
+	network := NewMemoryNetwork(1)
+	seed := startTestNode(t, network, "4170", nil)
+	peer := startTestNode(t, network, "4171", nil)
+
+	// a node without the predecessor list notifies with its NodeInfo alone, which must still decode
+	transport := network.Transport("127.0.0.1:4171")
+	err := transport.Call(peer.ctx, "127.0.0.1:4170", serviceName(&seed.info)+".NotifyRPC", &peer.info, &Empty{}, time.Second, nil)
+	if err != nil {
+		t.Fatalf("Notify with a NodeInfo failed: %v", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); !sameNode(seed.GetPredecessor(), &peer.info); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("The seed didn't take %v as predecessor, it has %v", peer.info, seed.GetPredecessor())
+		}
+	}
+}
+
+// legacyHandler is the RPCHandler of a node from before the handshake, it only answers GetLengthRPC.
+type legacyHandler struct{}
+
+func (handler *legacyHandler) GetLengthRPC(args *Empty, reply *GetLengthReply) error {
+	reply.IdentifierLength, reply.SuccessorsLength = 10, 2
+	return nil
+}
+
+func TestLegacyPeer(t *testing.T) {
+	network := NewMemoryNetwork(1)
+	server := rpc.NewServer()
+	if err := server.RegisterName(RPCHandlerName, &legacyHandler{}); err != nil {
+		t.Fatalf("Failed to register the legacy handler: %v", err)
+	}
+	legacy := network.Transport("127.0.0.1:4170")
+	if err := legacy.Listen("127.0.0.1:4170", server); err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(legacy.Close)
+
+	// a node from before the handshake has the same lengths, but speaks protocol version 0, it is refused
+	peer := startTestNode(t, network, "4171", nil)
+	_, err := peer.Remote(NewNodeInfoWithAddress("127.0.0.1", "4170")).Handshake(peer.ctx)
+	if !errors.Is(err, errNotCompatible) {
+		t.Fatalf("Handshake with a legacy node: got %v, want a %v error", err, errNotCompatible)
+	}
+	if peer.host.sessions.get("127.0.0.1:4170") != nil {
+		t.Fatalf("The node kept a session with the legacy node")
+	}
+}
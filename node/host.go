@@ -0,0 +1,46 @@
+package node
+
+import (
+	"net/rpc"
+	"sync/atomic"
+)
+
+// host is the physical part of a node: the RPC server and the transport.
+// All the virtual nodes of a process share one host, so they share one port.
+type host struct {
+	server    *rpc.Server // the RPC server, every identity registers its own handler in it
+	transport Transport   // listener and connections to the peers
+
+	latency    *latencyTable    // RTT estimate of the peers, measured by the calls
+	detector   *failureDetector // liveness of the peers, fed by the calls
+	sessions   *sessionTable    // the protocol version and features negotiated with each peer
+	identities *identityCache   // the peer identities checked against their keys, see peerCheck
+	sent       *sentMessages    // the membership messages sent without IdPolicySecure, see confirmSender
+
+	lastCount atomic.Pointer[ringCount] // the last exact size of the ring walked by an identity, nil before the first walk
+
+	nodes []*Node // the identities of the host, the primary first, set by NewNode before the host serves
+}
+
+// newHost creates the host of a node, the transport starts listening in startServer.
+func newHost(transport Transport) *host {
+	return &host{
+		server:     rpc.NewServer(),
+		transport:  transport,
+		latency:    newLatencyTable(),
+		detector:   newFailureDetector(),
+		sessions:   newSessionTable(),
+		identities: newIdentityCache(),
+		sent:       newSentMessages(),
+	}
+}
+
+// node returns the identity of the host with the identifier of the NodeInfo, nil if the host doesn't run it.
+func (host *host) node(nodeInfo *NodeInfo) *Node {
+	for _, node := range host.nodes {
+		if node.info.Identifier.Cmp(nodeInfo.Identifier) == 0 {
+			return node
+		}
+	}
+	return nil
+}
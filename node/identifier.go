@@ -0,0 +1,138 @@
+package node
+
+import (
+	"chord/log"
+	"chord/tools"
+	"context"
+	"fmt"
+	"math/big"
+)
+
+// IdPolicy decides how a node gets its identifier.
+type IdPolicy string
+
+const (
+	IdPolicyHash     IdPolicy = "hash"     // hash of ip:port, the default one
+	IdPolicyExplicit IdPolicy = "explicit" // given by the user (-i)
+	IdPolicyGap      IdPolicy = "gap"      // midpoint of the largest gap in the ring, chosen when joining
+	IdPolicySecure   IdPolicy = "secure"   // hash of the public key of the TLS certificate, checked by the peers
+)
+
+// ParseIdPolicy converts the string to an IdPolicy, only the policies that can be chosen by the user are accepted.
+func ParseIdPolicy(str string) (IdPolicy, error) {
+	switch IdPolicy(str) {
+	case IdPolicyHash, IdPolicyGap, IdPolicySecure:
+		return IdPolicy(str), nil
+	default:
+		return "", fmt.Errorf("unknown identifier policy %q", str)
+	}
+}
+
+// GenerateIdentifier returns the identifier of the name (e.g. a filename) in the node's ring,
+// cut from the node's hash to its m.
+func (node *Node) GenerateIdentifier(name string) *big.Int {
+	return tools.GenerateIdentifier(name, node.hashName, node.identifierLength)
+}
+
+// mod returns x mod 2^m in x, for the node's m.
+func (node *Node) mod(x *big.Int) *big.Int {
+	return tools.Mod(x, node.identifierLength)
+}
+
+// distance returns the clockwise distance from a to b on the node's ring.
+func (node *Node) distance(a, b *big.Int) *big.Int {
+	return tools.Distance(a, b, node.identifierLength)
+}
+
+// initialIdentifier returns the identifier the node starts with.
+// For the gap policy, the hash is used until the node joins (and also when it creates the ring).
+// The other identities of a physical node use the hash of ip:port#k, k being the index of the identity,
+// or the hash of key#k for the secure policy, see keyIdentifier.
+func (node *Node) initialIdentifier() (*big.Int, error) {
+	if node.idPolicy == IdPolicySecure {
+		if node.ownKey == nil {
+			return nil, fmt.Errorf("the secure identifier policy needs the key of the TLS certificate")
+		}
+		return node.keyIdentifier(node.ownKey, node.virtualIndex), nil
+	}
+	if node.virtualIndex > 0 {
+		return node.GenerateIdentifier(fmt.Sprintf("%s:%s#%d", node.info.IpAddress, node.info.Port, node.virtualIndex)), nil
+	}
+	switch node.idPolicy {
+	case IdPolicyExplicit:
+		if node.identifierOverride == nil {
+			return nil, fmt.Errorf("the explicit identifier policy needs an identifier")
+		}
+		identifier := new(big.Int).Set(node.identifierOverride)
+		return node.mod(identifier), nil
+	case IdPolicyHash, IdPolicyGap:
+		return node.GenerateIdentifier(node.info.IpAddress + ":" + node.info.Port), nil
+	default:
+		return nil, fmt.Errorf("unknown identifier policy %q", node.idPolicy)
+	}
+}
+
+// setIdentifier sets the node's identifier and the ideal identifiers of the finger table entries.
+// It can only be used before the node starts the server, as the node's Info should not be changed after that.
+func (node *Node) setIdentifier(identifier *big.Int) {
+	node.info.Identifier = identifier
+	for i := 0; i < node.identifierLength; i++ {
+		node.fingerIndex[i] = node.fingerEntryId(&node.info, i)
+	}
+}
+
+// assignGapIdentifier walks the ring from joinNode and moves the node to the midpoint of the largest gap.
+func (node *Node) assignGapIdentifier(ctx context.Context, joinNode *NodeInfo) error {
+	defer log.LogFunction()()
+
+	members, err := node.walkRing(ctx, joinNode)
+	if err != nil {
+		return fmt.Errorf("failed to walk the ring: %w", err)
+	}
+	identifier, err := node.largestGapMidpoint(members)
+	if err != nil {
+		return err
+	}
+	log.Info("The midpoint of the largest gap is %v", identifier)
+	node.setIdentifier(identifier)
+	return nil
+}
+
+// largestGapMidpoint finds the largest gap between two adjacent members and returns its midpoint.
+// members should be in ring order, as returned by walkRing.
+func (node *Node) largestGapMidpoint(members NodeInfoList) (*big.Int, error) {
+	if len(members) == 0 {
+		return nil, fmt.Errorf("no member in the ring")
+	}
+
+	var start, largest *big.Int
+	for i, member := range members {
+		next := members[(i+1)%len(members)]
+		gap := node.distance(member.Identifier, next.Identifier)
+		if len(members) == 1 {
+			gap = tools.TwoM(node.identifierLength) // a single node owns the whole ring
+		}
+		if largest == nil || tools.GreaterThan(gap, largest) {
+			start, largest = member.Identifier, gap
+		}
+	}
+
+	if largest.Cmp(big.NewInt(2)) < 0 {
+		return nil, fmt.Errorf("no free identifier in the ring")
+	}
+	midpoint := new(big.Int).Rsh(largest, 1)
+	midpoint.Add(midpoint, start)
+	return node.mod(midpoint), nil
+}
+
+// checkCollision checks if the successor found for the node's identifier already uses this identifier.
+// The same address is not a collision, it's the node itself joining again.
+func (node *Node) checkCollision(successor *NodeInfo) error {
+	if successor.Identifier.Cmp(node.info.Identifier) != 0 {
+		return nil
+	}
+	if successor.IpAddress == node.info.IpAddress && successor.Port == node.info.Port {
+		return nil
+	}
+	return fmt.Errorf("identifier %v is already used by %s:%s", node.info.Identifier, successor.IpAddress, successor.Port)
+}
@@ -2,13 +2,19 @@ package node
 
 import (
 	"chord/log"
+	"context"
+	"errors"
 	"fmt"
-	"os"
 	"time"
 )
 
-// Initialize begins the node, create or join
-func (node *Node) Initialize(mode, joinAddress, joinPort string) {
+// errNotCompatible marks a join failure that retrying can't fix, e.g. a different m or an identifier collision.
+var errNotCompatible = errors.New("not compatible with the ring")
+
+// Initialize begins the node, create or join.
+// To join, the node tries its bootstrap peers (see WithBootstrapPeers) until the join deadline,
+// it fails if none of them works by then, or if the ring is not compatible with the node.
+func (node *Node) Initialize(mode string) error {
 	defer log.LogFunction()()
 
 	switch mode {
@@ -16,7 +22,9 @@ func (node *Node) Initialize(mode, joinAddress, joinPort string) {
 		node.create()
 		fmt.Println("Create Chord Ring success")
 	case "join":
-		node.joinRing(joinAddress, joinPort)
+		if err := node.joinBootstrap(); err != nil {
+			return err
+		}
 		fmt.Println("Join Chord Ring success")
 	}
 
@@ -25,6 +33,28 @@ func (node *Node) Initialize(mode, joinAddress, joinPort string) {
 
 	// start the periodic tasks
 	node.StartPeriodicTasks()
+
+	// the other identities join through the node, which is in the ring now
+	node.joinVirtualNodes()
+	return nil
+}
+
+// joinVirtualNodes makes the other identities of the node join the ring, and starts their periodic tasks.
+// An identity that fails to join is not fatal, the node still works with the others.
+func (node *Node) joinVirtualNodes() {
+	defer log.LogFunction()()
+
+	for _, virtualNode := range node.virtualNodes {
+		if err := virtualNode.join(virtualNode.ctx, &node.info); err != nil {
+			log.Error("Virtual node %v failed to join, error: %v", virtualNode.info, err)
+			fmt.Printf("Virtual node %s failed to join, error: %v\n", virtualNode.info.Identifier.String(), err)
+			continue
+		}
+		if !node.manualScheduling {
+			virtualNode.startPeriodicTasks()
+		}
+		fmt.Printf("Virtual node %s joined\n", virtualNode.info.Identifier.String())
+	}
 }
 
 // Create a new ring.
@@ -38,105 +68,143 @@ func (node *Node) create() {
 	log.Info("node.Successors[0]: %v", node.info)
 }
 
-func (node *Node) joinRing(joinAddress, joinPort string) {
+// joinRing joins the ring through the join node, only known by its address.
+func (node *Node) joinRing(ctx context.Context, joinNode *NodeInfo) error {
 	// get full Info of join node
-	joinNode := NewNodeInfoWithAddress(joinAddress, joinPort)
-	joinNode, err := joinNode.GetNodeInfo()
+	joinNode, err := node.Remote(joinNode).GetNodeInfoContext(ctx)
 	if err != nil {
-		log.Error("Try to get join node Info failed, error: %v", err)
-		fmt.Printf("Try to get join node Info failed, error: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("try to get join node Info failed: %w", err)
 	}
 
-	// They should have the same IdentifierLength and SuccessorsLength
+	// They should speak a common protocol version, and have the same IdentifierLength and SuccessorsLength
 	// Otherwise, the join operation will fail
-	reply, err := joinNode.GetLength()
+	session, err := node.Remote(joinNode).Handshake(ctx)
 	if err != nil {
-		log.Error("Try to get join node length failed, error: %v", err)
-		fmt.Printf("Try to get join node length failed, error: %v\n", err)
-		os.Exit(1)
+		return fmt.Errorf("handshake with the join node failed: %w", err)
 	}
-	if reply.IdentifierLength != node.identifierLength || reply.SuccessorsLength != node.successorsLength {
-		log.Error("The join node has different IdentifierLength or SuccessorsLength")
-		fmt.Printf("The join node has different IdentifierLength or SuccessorsLength\n")
-		os.Exit(1)
+	log.Info("Handshake with the join node %v: %v", joinNode, session)
+
+	// with the gap policy, the node moves to the midpoint of the largest gap before joining
+	if node.idPolicy == IdPolicyGap {
+		if err := node.assignGapIdentifier(ctx, joinNode); err != nil {
+			return fmt.Errorf("try to assign the identifier failed: %w", err)
+		}
 	}
 
 	// join the chord ring
-	if err := node.join(joinNode); err != nil {
-		log.Error("Join Chord Ring failed, error: %v", err)
-		fmt.Printf("Join Chord Ring failed, error: %v\n", err)
-		os.Exit(1)
+	if err := node.join(ctx, joinNode); err != nil {
+		return fmt.Errorf("join Chord Ring failed: %w", err)
 	}
+	return nil
+}
+
+// checkLength checks that the join node uses the same identifier space (m) and the same number of successors (r).
+// Nodes with a different m would place the same key on different positions, so they can't live in one ring.
+func (node *Node) checkLength(reply *GetLengthReply) error {
+	if reply.IdentifierLength != node.identifierLength {
+		return fmt.Errorf("identifier length m is %d, but the join node uses %d", node.identifierLength, reply.IdentifierLength)
+	}
+	if reply.SuccessorsLength != node.successorsLength {
+		return fmt.Errorf("successors length r is %d, but the join node uses %d", node.successorsLength, reply.SuccessorsLength)
+	}
+	return nil
 }
 
 // Join an existing Chord ring containing node n' (joinNode).
-func (node *Node) join(joinNode *NodeInfo) error {
+func (node *Node) join(ctx context.Context, joinNode *NodeInfo) error {
 	defer log.LogFunction()()
 
 	log.Info("%v.join(%v)", node.info, joinNode)
 
 	// predecessor = nil
 	// successor = n'.find_successor(n)
-	nodeInfo, err := joinNode.FindSuccessorIter(node.info.Identifier)
+	nodeInfo, err := node.Remote(joinNode).Lookup(ctx, node.info.Identifier)
 	if err != nil {
 		log.Info("%v.find_successor(%v) failed, error: %v", joinNode, node.info, err)
 		return fmt.Errorf("%v.find_successor(%v) failed, error: %v", joinNode, node.info, err)
 	}
-	if err := nodeInfo.LiveCheck(); err != nil {
+	if err := node.Remote(nodeInfo).LiveCheckContext(ctx); err != nil {
 		log.Info("%v.find_successor(%v) has bad result: %v", joinNode, node.info, err)
 		return fmt.Errorf("%v.find_successor(%v) has bad result: %v", joinNode, node.info, err)
 	}
+	// the identifier must be unique in the ring
+	if err := node.checkCollision(nodeInfo); err != nil {
+		log.Info("Identifier collision: %v", err)
+		return fmt.Errorf("%w: %v", errNotCompatible, err)
+	}
 
 	node.SetFirstSuccessor(nodeInfo)
 	log.Info("Successfully join! Its successor is %v", nodeInfo)
+
+	// fill the finger table at once, instead of one entry per fixFingers run
+	node.initFingerTable(ctx, joinNode, nodeInfo)
 	return nil
 }
 
 func (node *Node) StartPeriodicTasks() {
-	go node.periodicStabilize(node.stabilizeTime)
-	go node.periodicFixFingers(node.fixFingersTime)
-	go node.periodicCheckPredecessor(node.checkPredecessorTime)
+	if node.manualScheduling {
+		return // the caller runs the tasks
+	}
+	node.startPeriodicTasks()
 
 	fmt.Println("Waiting for periodic tasks to stabilize...")
 	// Sleep for a duration to allow periodic tasks to stabilize
 	time.Sleep(5 * time.Second) // Adjust the duration as needed
 }
 
-func (node *Node) periodicStabilize(stabilizeTime time.Duration) {
-	ticker := time.NewTicker(stabilizeTime * time.Millisecond)
+// startPeriodicTasks starts the periodic tasks without waiting for them.
+func (node *Node) startPeriodicTasks() {
+	go node.periodicStabilize()
+	go node.periodicFixFingers()
+	go node.periodicCheckPredecessor()
+	go node.periodicCheckPartition(node.stabilizeTime * partitionCheckFactor)
+}
+
+// Stabilize runs the stabilize task once, for the callers running the periodic tasks themselves, see WithManualScheduling.
+func (node *Node) Stabilize() {
+	node.stabilize(node.ctx)
+}
+
+// FixFingers runs the fixFingers task once, see Stabilize.
+func (node *Node) FixFingers() {
+	node.fixFingers(node.ctx)
+}
+
+// CheckPredecessor runs the checkPredecessor task once, see Stabilize.
+func (node *Node) CheckPredecessor() {
+	node.checkPredecessor(node.ctx)
+}
+
+// The periodic tasks wait interval.next() before each run, instead of using a ticker, as the interval may change.
+
+func (node *Node) periodicStabilize() {
 	for {
 		select {
-		case <-ticker.C:
-			node.stabilize()
+		case <-time.After(node.stabilizeInterval.next()):
+			node.stabilize(node.ctx)
 		case <-node.shutdownCh:
-			ticker.Stop()
 			return
 		}
 	}
 }
 
-func (node *Node) periodicFixFingers(fixFingersTime time.Duration) {
-	ticker := time.NewTicker(fixFingersTime * time.Millisecond)
+func (node *Node) periodicFixFingers() {
 	for {
 		select {
-		case <-ticker.C:
-			node.fixFingers()
+		case <-time.After(node.fixFingersInterval.next()):
+			node.fixFingers(node.ctx)
 		case <-node.shutdownCh:
-			ticker.Stop()
 			return
 		}
 	}
 }
 
-func (node *Node) periodicCheckPredecessor(checkPredecessorTime time.Duration) {
-	ticker := time.NewTicker(checkPredecessorTime * time.Millisecond)
+func (node *Node) periodicCheckPredecessor() {
 	for {
 		select {
-		case <-ticker.C:
-			node.checkPredecessor()
+		case <-time.After(node.checkPredecessorInterval.next()):
+			node.checkPredecessor(node.ctx)
 		case <-node.shutdownCh:
-			ticker.Stop()
 			return
 		}
 	}
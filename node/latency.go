@@ -0,0 +1,117 @@
+package node
+
+import (
+	"chord/log"
+	"chord/tools"
+	"context"
+	"sync"
+	"time"
+)
+
+// rttSmoothing is the weight of a new sample in the RTT estimate, the same 1/8 as TCP's SRTT.
+const rttSmoothing = 0.125
+
+// proximityCandidates is the number of fingers compared by findNearestNodeInFingers,
+// the ones making the most progress toward the identifier.
+const proximityCandidates = 3
+
+// rttMethods are the RPCs whose duration is a fair RTT sample,
+// the others do some work (lookups, file transfers) before answering.
+var rttMethods = map[string]bool{
+	"PingRPC":           true,
+	"GetInfoRPC":        true,
+	"GetLengthRPC":      true,
+	"HelloRPC":          true,
+	"GetPredecessorRPC": true,
+	"GetSuccessorsRPC":  true,
+}
+
+// latencyTable keeps a smoothed RTT estimate per peer address.
+// It lives in the host, as the virtual nodes of a peer share its network distance.
+type latencyTable struct {
+	mu   sync.RWMutex
+	rtts map[string]time.Duration
+}
+
+func newLatencyTable() *latencyTable {
+	return &latencyTable{rtts: make(map[string]time.Duration)}
+}
+
+// observe adds an RTT sample of the address to its estimate.
+func (table *latencyTable) observe(address string, sample time.Duration) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	rtt, ok := table.rtts[address]
+	if !ok {
+		table.rtts[address] = sample
+		return
+	}
+	table.rtts[address] = rtt + time.Duration(rttSmoothing*float64(sample-rtt))
+}
+
+// rtt returns the RTT estimate of the address, false if it has never been measured.
+func (table *latencyTable) rtt(address string) (time.Duration, bool) {
+	table.mu.RLock()
+	defer table.mu.RUnlock()
+	rtt, ok := table.rtts[address]
+	return rtt, ok
+}
+
+// rttOf returns the node's RTT estimate of the peer, false if it has never been measured.
+func (node *Node) rttOf(nodeInfo *NodeInfo) (time.Duration, bool) {
+	return node.host.latency.rtt(nodeInfo.IpAddress + ":" + nodeInfo.Port)
+}
+
+// closestByRTT returns the candidate with the lowest RTT estimate.
+// The candidates are given in order of preference, a candidate never measured only wins if no one is measured.
+func (node *Node) closestByRTT(candidates NodeInfoList) *NodeInfo {
+	best := candidates[0]
+	bestRTT, bestKnown := node.rttOf(best)
+	for _, candidate := range candidates[1:] {
+		rtt, known := node.rttOf(candidate)
+		if known && (!bestKnown || rtt < bestRTT) {
+			best, bestRTT, bestKnown = candidate, rtt, true
+		}
+	}
+	return best
+}
+
+// proximityFinger chooses the entry of finger[i] among the nodes in [n+2^i, n+2^(i+1)).
+// Any node in this interval keeps the lookups within O(log N) hops, so we take the closest one on the network.
+// The candidates are the successor of n+2^i and its successors, the ones we can't reach are left out.
+func (node *Node) proximityFinger(ctx context.Context, i int, successor *NodeInfo) *NodeInfo {
+	defer log.LogFunction()()
+
+	start := node.fingerIndex[i]
+	end := node.info.Identifier // the last interval ends at n itself
+	if i+1 < node.identifierLength {
+		end = node.fingerIndex[i+1]
+	}
+	if !tools.ModIntervalCheck(successor.Identifier, start, end, true, false) {
+		// the interval is empty, the successor is the only choice
+		return successor
+	}
+
+	candidates := NodeInfoList{successor}
+	successors, err := node.Remote(successor).GetSuccessorsContext(ctx)
+	if err != nil {
+		log.Error("Failed to get the successors of %v: %v", successor, err)
+		return successor
+	}
+	for _, candidate := range successors {
+		if candidate.Empty() || !tools.ModIntervalCheck(candidate.Identifier, start, end, true, false) {
+			continue
+		}
+		if _, known := node.rttOf(candidate); !known {
+			// measure it, the ping is recorded by callRPC
+			if err := node.Remote(candidate).LiveCheckContext(ctx); err != nil {
+				continue
+			}
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	finger := node.closestByRTT(candidates)
+	log.Info("finger[%d]: %d candidates in [%v, %v), choose %v", i, len(candidates), start, end, finger)
+	return finger
+}
@@ -3,12 +3,15 @@ package node
 import (
 	"chord/storage"
 	"chord/tools"
+	"context"
+	"crypto"
 	"crypto/tls"
 	"fmt"
 	"math/big"
 	"path/filepath"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -25,6 +28,15 @@ func NewNodeInfo() *NodeInfo {
 	return &NodeInfo{Identifier: tools.Infinity}
 }
 
+// newEmptyList returns a list of n empty entries.
+func newEmptyList(n int) NodeInfoList {
+	list := make(NodeInfoList, n)
+	for i := range list {
+		list[i] = NewNodeInfo()
+	}
+	return list
+}
+
 func NewNodeInfoWithAddress(ipAddress string, port string) *NodeInfo {
 	return &NodeInfo{
 		Identifier: tools.Infinity,
@@ -39,8 +51,8 @@ func (nodeInfo *NodeInfo) Empty() bool {
 	if nodeInfo == nil {
 		return true
 	}
-	// identifier is not in [0, 2^m-1]
-	b1 := !tools.InInterval(nodeInfo.Identifier, big.NewInt(0), tools.TwoM, true, false)
+	// identifier is not in [0, 2^m-1], for any m, e.g. it is Infinity
+	b1 := !tools.InInterval(nodeInfo.Identifier, big.NewInt(0), tools.TwoM(tools.MaxIdentifierLength), true, false)
 	b2 := nodeInfo.IpAddress == ""
 	b3 := nodeInfo.Port == ""
 	return b1 || b2 || b3
@@ -57,15 +69,28 @@ type Node struct {
 	identifierLength int // Important
 	successorsLength int // Important
 
-	info        NodeInfo
-	predecessor *NodeInfo
-	successors  NodeInfoList
-	fingerTable NodeInfoList
-	fingerIndex []*big.Int
+	predecessorsLength int // the length of the predecessor list, the successors length by default
+
+	info         NodeInfo
+	predecessors NodeInfoList // predecessors[0] is the predecessor, the next ones are its own predecessors
+	successors   NodeInfoList
+	fingerTable  NodeInfoList
+	fingerIndex  []*big.Int
 
-	muPre sync.RWMutex
-	muSuc sync.RWMutex
-	muFin sync.RWMutex
+	nextFinger     int // the cursor of fixFingers, the last refreshed entry
+	fingersPerTick int // the number of entries refreshed by each fixFingers run
+
+	muPre   sync.RWMutex
+	muSuc   sync.RWMutex
+	muFin   sync.RWMutex
+	muState sync.RWMutex
+
+	state          RingState     // joined, isolated or rejoining
+	bootstrapPeers NodeInfoList  // the peers to join through, and to rejoin through when no known node works, only known by their address
+	joinDeadline   time.Duration // how long the bootstrap peers are tried when joining
+	peerMemory     *peerMemory   // distant peers seen before, to find the other ring after a partition
+	seed           int64         // the seed of random, see WithSeed
+	random         *random       // the random choices of the node
 
 	localStorage   storage.Storage   // Storage for this node
 	backupStorages []storage.Storage // Storages for successor nodes
@@ -74,7 +99,39 @@ type Node struct {
 	fixFingersTime       time.Duration
 	checkPredecessorTime time.Duration
 
-	shutdownCh chan struct{} // channel for shutdown
+	manualScheduling         bool              // the caller runs the periodic tasks, see WithManualScheduling
+	adaptiveIntervals        bool              // the intervals adapt to the churn, see adaptiveInterval
+	stabilizeInterval        *adaptiveInterval // effective interval of stabilize
+	fixFingersInterval       *adaptiveInterval // effective interval of fixFingers
+	checkPredecessorInterval *adaptiveInterval // effective interval of checkPredecessor
+
+	hashName           string        // the hash the identifiers are cut from, chosen when the ring is created
+	idPolicy           IdPolicy      // how the identifier is assigned
+	identifierOverride *big.Int      // the identifier given by the user, only used by IdPolicyExplicit
+	ownKey             []byte        // the public key of the TLS certificate, DER encoded, only used by IdPolicySecure
+	signer             crypto.Signer // the private key of the TLS certificate, signs the membership messages with IdPolicySecure
+
+	lookupMode LookupMode // iterative or recursive lookups
+
+	extraFeatures []Feature // the optional features announced in the handshake, on top of the built-in ones
+
+	leaving    atomic.Bool        // set while the node hands off its files, it refuses new files then
+	shutdownCh chan struct{}      // channel for shutdown
+	stopOnce   sync.Once          // closes shutdownCh once, so Close can follow Quit
+	ctx        context.Context    // bounds the node's own calls (periodic tasks, RPC handlers), canceled on shutdown
+	cancel     context.CancelFunc // cancels ctx
+
+	host        *host         // RPC server and transport, shared by the virtual nodes
+	transport   Transport     // the transport given by WithTransport, TCP (or TLS) by default
+	callTimeout time.Duration // deadline of each RPC call
+
+	clock             Clock   // the time of the failure detector
+	phiThreshold      float64 // the suspicion level at which a peer is dead, see failureDetector
+	suspicionFailures int     // the failed calls in a row needed before a peer is dead
+
+	virtualIndex int     // index of this identity on the physical node, 0 for the primary node
+	virtualCount int     // number of identities of the physical node
+	virtualNodes []*Node // the other identities, only set on the primary node
 
 	tlsBool         bool
 	serverTLSConfig *tls.Config
@@ -95,79 +152,158 @@ func NewNode(
 	tlsBool bool,
 	serverTLSConfig *tls.Config,
 	clientTLSConfig *tls.Config,
+	options ...Option,
 ) (*Node, error) {
-	// you have to set the identifier length for the tools package first
-	tools.SetIdentifierLength(identifierLength)
-
-	networkAddress := ipAddress + ":" + port
-	identifier := tools.GenerateIdentifier(networkAddress)
-
 	nodeInfo := NodeInfo{
-		Identifier: identifier,
-		IpAddress:  ipAddress,
-		Port:       port,
-	}
-
-	localStorage, err := storageFactory(storagePath)
-	if err != nil {
-		return nil, fmt.Errorf("error creating storage: %w", err)
-	}
-
-	backupStorages := make([]storage.Storage, successorsLength)
-	for i := 0; i < successorsLength; i++ {
-		backupPathI := filepath.Join(backupPath, strconv.Itoa(i))
-		backupStorages[i], err = storageFactory(backupPathI)
-		if err != nil {
-			return nil, fmt.Errorf("error creating backup storage %d: %w", i, err)
-		}
+		IpAddress: ipAddress,
+		Port:      port,
 	}
 
 	node := &Node{
 		identifierLength:     identifierLength,
 		successorsLength:     successorsLength,
 		info:                 nodeInfo,
-		predecessor:          NewNodeInfo(),
+		predecessorsLength:   successorsLength,
 		successors:           make(NodeInfoList, successorsLength), // fixed size, should not use append later, but use index
 		fingerTable:          make(NodeInfoList, identifierLength), // fixed size, should not use append later, but use index
 		fingerIndex:          make([]*big.Int, identifierLength),
-		localStorage:         localStorage,
-		backupStorages:       backupStorages,
 		stabilizeTime:        stabilizeTime,
 		fixFingersTime:       fixFingersTime,
 		checkPredecessorTime: checkPredecessorTime,
+		fingersPerTick:       1,
+		idPolicy:             IdPolicyHash,
+		lookupMode:           LookupIterative,
+		state:                StateJoined,
+		joinDeadline:         defaultJoinDeadline,
+		seed:                 time.Now().UnixNano(),
+		callTimeout:          defaultCallTimeout,
+		clock:                systemClock{},
+		phiThreshold:         defaultPhiThreshold,
+		suspicionFailures:    defaultSuspicionFailures,
+		virtualCount:         1,
 		shutdownCh:           make(chan struct{}),
+		hashName:             tools.DefaultHash,
 		tlsBool:              tlsBool,
 		serverTLSConfig:      serverTLSConfig,
 		clientTLSConfig:      clientTLSConfig,
 	}
 
+	for _, option := range options {
+		option(node)
+	}
+	// the identifiers are cut from the digest of the hash, so they can't be longer than it
+	if err := tools.CheckHash(node.hashName); err != nil {
+		return nil, err
+	}
+	if bits := tools.HashBits(node.hashName); identifierLength > bits {
+		return nil, fmt.Errorf("identifier length m is %d, but the %s digest only has %d bits", identifierLength, node.hashName, bits)
+	}
+	// the secure identifiers come from the key of the certificate, the peers check them in the TLS handshake
+	if node.secureIdentifiers() {
+		if !tlsBool {
+			return nil, fmt.Errorf("the secure identifier policy needs TLS")
+		}
+		key, err := certificateKey(serverTLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		node.ownKey = key
+		signer, ok := serverTLSConfig.Certificates[0].PrivateKey.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("the key of the TLS certificate can't sign")
+		}
+		node.signer = signer
+		node.serverTLSConfig, node.clientTLSConfig = secureTLSConfigs(serverTLSConfig, clientTLSConfig)
+	}
+	node.ctx, node.cancel = context.WithCancel(context.Background())
+	node.predecessors = newEmptyList(node.predecessorsLength)
+	// the identities of a host make different choices from the same seed
+	node.random = newRandom(node.seed + int64(node.virtualIndex))
+	node.peerMemory = newPeerMemory(node.random)
+	node.stabilizeInterval = newAdaptiveInterval(stabilizeTime*time.Millisecond, node.adaptiveIntervals, node.random)
+	node.fixFingersInterval = newAdaptiveInterval(fixFingersTime*time.Millisecond, node.adaptiveIntervals, node.random)
+	node.checkPredecessorInterval = newAdaptiveInterval(checkPredecessorTime*time.Millisecond, node.adaptiveIntervals, node.random)
+	if node.host == nil {
+		if node.transport == nil {
+			var serverTLSConfig *tls.Config
+			if tlsBool {
+				serverTLSConfig = node.serverTLSConfig
+			}
+			node.transport = newTCPTransport(node.dial, serverTLSConfig)
+		}
+		node.host = newHost(node.transport)
+	}
+
+	// with virtual nodes, each identity has its own partition under the storage root
+	nodeStoragePath, nodeBackupPath := storagePath, backupPath
+	if node.virtualCount > 1 {
+		nodeStoragePath = filepath.Join(storagePath, strconv.Itoa(node.virtualIndex))
+		nodeBackupPath = filepath.Join(backupPath, strconv.Itoa(node.virtualIndex))
+	}
+
+	localStorage, err := storageFactory(nodeStoragePath)
+	if err != nil {
+		return nil, fmt.Errorf("error creating storage: %w", err)
+	}
+	node.localStorage = localStorage
+
+	node.backupStorages = make([]storage.Storage, successorsLength)
+	for i := 0; i < successorsLength; i++ {
+		backupPathI := filepath.Join(nodeBackupPath, strconv.Itoa(i))
+		node.backupStorages[i], err = storageFactory(backupPathI)
+		if err != nil {
+			return nil, fmt.Errorf("error creating backup storage %d: %w", i, err)
+		}
+	}
+
+	// the identifier comes from the policy, the gap policy starts with the hash and moves when joining
+	identifier, err := node.initialIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	node.setIdentifier(identifier)
+	node.host.nodes = append(node.host.nodes, node)
+
 	// Initialize each NodeInfo
 	for i := 0; i < successorsLength; i++ {
 		node.successors[i] = NewNodeInfo()
 	}
 	for i := 0; i < identifierLength; i++ {
 		node.fingerTable[i] = NewNodeInfo()
-		node.fingerIndex[i] = fingerEntryId(&node.info, i)
 	}
 
-	// Record it in the localNode
-	localNode = node
+	// the primary node creates the other identities, they share its host and its settings
+	if node.virtualIndex == 0 {
+		for k := 1; k < node.virtualCount; k++ {
+			virtualOptions := append(append([]Option{}, options...), withVirtualIndex(k, node.host))
+			virtualNode, err := NewNode(
+				identifierLength, successorsLength, ipAddress, port,
+				storageFactory, storagePath, backupPath,
+				stabilizeTime, fixFingersTime, checkPredecessorTime,
+				tlsBool, serverTLSConfig, clientTLSConfig,
+				virtualOptions...,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("error creating virtual node %d: %w", k, err)
+			}
+			node.virtualNodes = append(node.virtualNodes, virtualNode)
+		}
+	}
 
 	return node, nil
 }
 
-// fingerEntryId calculates the finger table's entry's (ideal) identifier.
-func fingerEntryId(nodeInfo *NodeInfo, i int) *big.Int {
+// identities returns the node and its virtual nodes, the node first.
+func (node *Node) identities() []*Node {
+	return append([]*Node{node}, node.virtualNodes...)
+}
+
+// fingerEntryId calculates the finger table's entry's (ideal) identifier, in the node's ring.
+func (node *Node) fingerEntryId(nodeInfo *NodeInfo, i int) *big.Int {
 	// (node.Identifier + 2^i) mod 2^m
 	twoI := new(big.Int).Exp(big.NewInt(2), big.NewInt(int64(i)), nil)
 	nTwoI := new(big.Int).Add(nodeInfo.Identifier, twoI)
-	return nTwoI.And(nTwoI, tools.TwoMMinusOne)
+	return node.mod(nTwoI)
 }
 
 /*                             Node Part                             */
-
-/*                             global local node                             */
-
-var localNode *Node
-
-/*                             global local node                             */
@@ -0,0 +1,152 @@
+package node
+
+import (
+	"math/big"
+	"time"
+)
+
+// Option is an optional setting of the node, passed to NewNode.
+// The required settings stay as parameters of NewNode, the optional ones have a default value and can be changed here.
+type Option func(node *Node)
+
+// WithIdentifier pins the node's identifier, instead of hashing its address.
+// The identifier is reduced mod 2^m.
+func WithIdentifier(identifier *big.Int) Option {
+	return func(node *Node) {
+		node.idPolicy = IdPolicyExplicit
+		node.identifierOverride = identifier
+	}
+}
+
+// WithIdPolicy chooses how the node's identifier is assigned, see IdPolicy.
+func WithIdPolicy(idPolicy IdPolicy) Option {
+	return func(node *Node) {
+		node.idPolicy = idPolicy
+	}
+}
+
+// WithLookupMode chooses how the node's lookups walk the ring, see LookupMode.
+func WithLookupMode(lookupMode LookupMode) Option {
+	return func(node *Node) {
+		node.lookupMode = lookupMode
+	}
+}
+
+// WithCallTimeout sets the deadline of each RPC call, so a hung peer can't block the node forever.
+func WithCallTimeout(callTimeout time.Duration) Option {
+	return func(node *Node) {
+		node.callTimeout = callTimeout
+	}
+}
+
+// WithVirtualNodes gives the node n identifiers in the ring, they share its listener and its storage root.
+// More identifiers per node spread the keys more evenly between the nodes.
+func WithVirtualNodes(n int) Option {
+	return func(node *Node) {
+		node.virtualCount = n
+	}
+}
+
+// withVirtualIndex makes the node the k-th identity of a physical node, sharing the host of the primary node.
+// It is only used by NewNode, when the primary node creates the other identities.
+func withVirtualIndex(k int, host *host) Option {
+	return func(node *Node) {
+		node.virtualIndex = k
+		node.host = host
+	}
+}
+
+// WithBootstrapPeers sets the peers the node joins through, they are only known by their address (see NewNodeInfoWithAddress).
+// They are also the last resort of an isolated node to rejoin the ring.
+func WithBootstrapPeers(peers NodeInfoList) Option {
+	return func(node *Node) {
+		node.bootstrapPeers = peers
+	}
+}
+
+// WithJoinDeadline sets how long the bootstrap peers are tried before the join gives up.
+func WithJoinDeadline(joinDeadline time.Duration) Option {
+	return func(node *Node) {
+		node.joinDeadline = joinDeadline
+	}
+}
+
+// WithAdaptiveIntervals makes the intervals of the periodic tasks adapt to the churn, see adaptiveInterval.
+func WithAdaptiveIntervals() Option {
+	return func(node *Node) {
+		node.adaptiveIntervals = true
+	}
+}
+
+// WithFingersPerTick sets the number of finger table entries refreshed by each fixFingers run, 1 by default.
+func WithFingersPerTick(fingersPerTick int) Option {
+	return func(node *Node) {
+		node.fingersPerTick = fingersPerTick
+	}
+}
+
+// WithPredecessorsLength sets the length of the predecessor list, the successors length r by default.
+func WithPredecessorsLength(predecessorsLength int) Option {
+	return func(node *Node) {
+		node.predecessorsLength = predecessorsLength
+	}
+}
+
+// WithSuspicion tunes the failure detector: a peer is dead when its suspicion level phi reaches phiThreshold
+// and at least failures calls to it failed in a row.
+func WithSuspicion(phiThreshold float64, failures int) Option {
+	return func(node *Node) {
+		node.phiThreshold = phiThreshold
+		node.suspicionFailures = failures
+	}
+}
+
+// WithHash chooses the hash the identifiers of the nodes and the keys are cut from, see tools.CheckHash.
+// All the nodes of a ring must use the same hash, SHA-1 by default, the others are refused when they join.
+func WithHash(name string) Option {
+	return func(node *Node) {
+		node.hashName = name
+	}
+}
+
+// WithFeatures announces optional features in the handshake, e.g. FeatureEncryption when the clients encrypt the files.
+// The built-in features (recursive lookups, TLS) are announced anyway.
+func WithFeatures(features ...Feature) Option {
+	return func(node *Node) {
+		node.extraFeatures = append(node.extraFeatures, features...)
+	}
+}
+
+// WithTransport makes the node talk to the other nodes through the transport, instead of net/rpc over TCP (or TLS),
+// e.g. MemoryNetwork.Transport in the tests.
+func WithTransport(transport Transport) Option {
+	return func(node *Node) {
+		node.transport = transport
+	}
+}
+
+// WithClock gives the node the clock of its failure detector and of its RTT measurements,
+// e.g. the virtual clock of a simulation.
+func WithClock(clock Clock) Option {
+	return func(node *Node) {
+		node.clock = clock
+	}
+}
+
+// WithSeed seeds the random choices of the node (the order of the peers it tries, the jitter of the waits),
+// e.g. from the seed of a simulation. By default the seed is the time the node is created.
+func WithSeed(seed int64) Option {
+	return func(node *Node) {
+		node.seed = seed
+	}
+}
+
+// WithManualScheduling makes the node start no goroutine of its own, for the simulations that must be deterministic:
+// Initialize doesn't start the periodic tasks, the caller runs them with Stabilize, FixFingers and CheckPredecessor,
+// the RPC handlers that answer before doing their work (e.g. Notify) finish their work before answering,
+// and a failed join returns after one round of the bootstrap peers instead of waiting to retry.
+func WithManualScheduling() Option {
+	return func(node *Node) {
+		node.manualScheduling = true
+	}
+}
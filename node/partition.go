@@ -0,0 +1,157 @@
+package node
+
+import (
+	"chord/log"
+	"chord/tools"
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	maxRememberedPeers   = 32 // the number of distant peers remembered to detect a partition
+	partitionProbes      = 3  // the number of peers asked in each partition check
+	partitionCheckFactor = 10 // the partition check runs once every partitionCheckFactor stabilizations
+)
+
+// peerMemory remembers some distant peers the node has seen, old finger table entries for example.
+// After a partition, they may be in the other ring, so they are the way back to it.
+type peerMemory struct {
+	mu     sync.Mutex
+	peers  map[string]*NodeInfo // keyed by the identifier
+	random *random              // the node's source, the map order is random too but not seeded
+}
+
+func newPeerMemory(random *random) *peerMemory {
+	return &peerMemory{peers: make(map[string]*NodeInfo), random: random}
+}
+
+// remember adds the peer, when the memory is full a random peer is forgotten, which keeps the peers diverse.
+func (memory *peerMemory) remember(peer *NodeInfo) {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	key := peer.Identifier.String()
+	if _, ok := memory.peers[key]; ok {
+		return
+	}
+	if len(memory.peers) >= maxRememberedPeers {
+		keys := memory.sortedKeys()
+		delete(memory.peers, keys[memory.random.Intn(len(keys))])
+	}
+	memory.peers[key] = peer
+}
+
+// forget removes the peer, e.g. when it doesn't answer anymore.
+func (memory *peerMemory) forget(peer *NodeInfo) {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	delete(memory.peers, peer.Identifier.String())
+}
+
+// list returns the remembered peers, in random order.
+func (memory *peerMemory) list() NodeInfoList {
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	peers := make(NodeInfoList, 0, len(memory.peers))
+	for _, key := range memory.sortedKeys() {
+		peers = append(peers, memory.peers[key])
+	}
+	memory.random.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	return peers
+}
+
+// sortedKeys returns the keys of the peers sorted, the caller holds the lock.
+func (memory *peerMemory) sortedKeys() []string {
+	keys := make([]string, 0, len(memory.peers))
+	for key := range memory.peers {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// rememberPeer remembers the peer as a distant peer, the node itself and its virtual nodes are left out.
+func (node *Node) rememberPeer(peer *NodeInfo) {
+	if peer.Empty() || node.onThisHost(peer) {
+		return
+	}
+	node.peerMemory.remember(peer)
+}
+
+// checkPartition looks up the node's own identifier through some remembered peers and the bootstrap peers.
+// In one consistent ring, the answer is the node itself. Any other answer means the peer lives in another ring,
+// e.g. after a network partition heals, and the node merges into it.
+func (node *Node) checkPartition(ctx context.Context) {
+	defer log.LogFunction()()
+
+	if node.GetState() != StateJoined {
+		return // the rejoin takes care of it
+	}
+
+	probes := append(node.peerMemory.list(), node.bootstrapPeers...)
+	asked := 0
+	for _, peer := range probes {
+		if asked >= partitionProbes {
+			break
+		}
+		owner, err := node.Remote(peer).Lookup(ctx, node.info.Identifier)
+		if err != nil {
+			log.Info("Partition check through %s:%s failed: %v", peer.IpAddress, peer.Port, err)
+			if !peer.Empty() {
+				node.peerMemory.forget(peer) // the bootstrap peers, only known by their address, are kept
+			}
+			continue
+		}
+		asked++
+		if owner.Identifier.Cmp(node.info.Identifier) == 0 {
+			continue
+		}
+		log.Info("%s:%s thinks %v owns %v, it lives in another ring, merge", peer.IpAddress, peer.Port, owner, node.info.Identifier)
+		node.merge(ctx, peer)
+		return
+	}
+}
+
+// merge takes the first node after the node in the peer's ring as the successor, if it is closer than the current one.
+// It notifies the successor at once and fills the finger table again from it, the old fingers only know the old ring.
+// The files move to their owners as the predecessors change,
+// the other nodes of both rings follow through their own checks and stabilizations.
+func (node *Node) merge(ctx context.Context, peer *NodeInfo) {
+	defer log.LogFunction()()
+
+	successor, err := node.lookupFrom(ctx, peer)
+	if err != nil {
+		log.Error("Failed to find the successor in the other ring: %v", err)
+		return
+	}
+	current := node.GetFirstSuccessor()
+	if current.Identifier.Cmp(node.info.Identifier) != 0 &&
+		!tools.ModIntervalCheck(successor.Identifier, node.info.Identifier, current.Identifier, false, false) {
+		log.Info("%v is not closer than the successor %v, keep it", successor, current)
+		return
+	}
+	log.Info("Merge into the other ring, the successor is now %v", successor)
+	node.SetFirstSuccessor(successor)
+	if err := node.Remote(successor).NotifyContext(ctx, &node.info, node.GetPredecessors()); err != nil {
+		log.Error("Failed to notify the successor %v: %v", successor, err)
+	}
+
+	for i := 0; i < node.identifierLength; i++ {
+		node.SetFingerEntry(i, NewNodeInfo())
+	}
+	node.initFingerTable(ctx, successor, successor)
+}
+
+func (node *Node) periodicCheckPartition(checkPartitionTime time.Duration) {
+	ticker := time.NewTicker(checkPartitionTime * time.Millisecond)
+	for {
+		select {
+		case <-ticker.C:
+			node.checkPartition(node.ctx)
+		case <-node.shutdownCh:
+			ticker.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package node
+
+import (
+	cfs "chord/cachefilesystem"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func startTestNode(t *testing.T, network *MemoryNetwork, port string, joinNode *Node, options ...Option) *Node {
+	dir := t.TempDir()
+	node, err := NewNode(10, 2, "127.0.0.1", port, cfs.CacheStorageFactory,
+		filepath.Join(dir, "storage"), filepath.Join(dir, "backup"), 50, 20, 50, false, nil, nil,
+		append(options, WithTransport(network.Transport("127.0.0.1:"+port)))...)
+	if err != nil {
+		t.Fatalf("Failed to create node %s: %v", port, err)
+	}
+	if joinNode == nil {
+		node.create()
+	} else if err := node.joinRing(node.ctx, NewNodeInfoWithAddress("127.0.0.1", joinNode.info.Port)); err != nil {
+		t.Fatalf("Node %s failed to join: %v", port, err)
+	}
+	node.startServer()
+	if !node.manualScheduling {
+		node.startPeriodicTasks()
+	}
+	node.joinVirtualNodes()
+	t.Cleanup(node.Close)
+	return node
+}
+
+// ringOf follows the successors from the node and returns the ports of the ring members, in ring order.
+// It returns nil if the walk doesn't come back to the node.
+func ringOf(start *Node, nodes map[string]*Node) []string {
+	var members []string
+	current := start
+	for i := 0; i <= len(nodes); i++ {
+		members = append(members, current.info.Port)
+		next, ok := nodes[current.GetFirstSuccessor().Port]
+		if !ok {
+			return nil
+		}
+		if next == start {
+			return members
+		}
+		current = next
+	}
+	return nil
+}
+
+// waitForRing waits until the nodes form exactly one ring, in identifier order.
+func waitForRing(t *testing.T, nodes []*Node, timeout time.Duration) {
+	byPort := make(map[string]*Node)
+	for _, node := range nodes {
+		byPort[node.info.Port] = node
+	}
+	sorted := append([]*Node{}, nodes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].info.Identifier.Cmp(sorted[j].info.Identifier) < 0 })
+	var want []string
+	for _, node := range sorted {
+		want = append(want, node.info.Port)
+	}
+
+	deadline := time.Now().Add(timeout)
+	var got []string
+	for time.Now().Before(deadline) {
+		got = ringOf(sorted[0], byPort)
+		if fmt.Sprint(got) == fmt.Sprint(want) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("Nodes don't form one ring after %v: want %v, got %v", timeout, want, got)
+}
+
+func TestPartitionMerge(t *testing.T) {
+	network := NewMemoryNetwork(1)
+
+	var nodes []*Node
+	for i := 0; i < 6; i++ {
+		var joinNode *Node
+		if i > 0 {
+			joinNode = nodes[0]
+		}
+		nodes = append(nodes, startTestNode(t, network, strconv.Itoa(26100+i), joinNode))
+	}
+	waitForRing(t, nodes, 10*time.Second)
+	// let the fingers point across the ring, they are remembered as distant peers
+	time.Sleep(2 * time.Second)
+
+	// split the ring in two interleaved halves
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].info.Identifier.Cmp(nodes[j].info.Identifier) < 0 })
+	var left, right []*Node
+	var leftAddresses, rightAddresses []string
+	for i, node := range nodes {
+		address := node.info.IpAddress + ":" + node.info.Port
+		if i%2 == 0 {
+			left = append(left, node)
+			leftAddresses = append(leftAddresses, address)
+		} else {
+			right = append(right, node)
+			rightAddresses = append(rightAddresses, address)
+		}
+	}
+	network.Partition(leftAddresses, rightAddresses)
+	waitForRing(t, left, 10*time.Second)
+	waitForRing(t, right, 10*time.Second)
+
+	// heal the partition, the two rings must merge
+	network.Heal()
+	waitForRing(t, nodes, 30*time.Second)
+}
+
+func TestMergeNotifiesAndResetsFingers(t *testing.T) {
+	network := NewMemoryNetwork(1)
+	node := startTestNode(t, network, "26110", nil, WithManualScheduling())
+	other := startTestNode(t, network, "26111", nil, WithManualScheduling())
+	stale := &NodeInfo{Identifier: other.info.Identifier, IpAddress: "127.0.0.1", Port: "26112"}
+	for i := 0; i < node.identifierLength; i++ {
+		node.SetFingerEntry(i, stale) // a finger from before the partition, in neither ring now
+	}
+
+	node.merge(node.ctx, &other.info)
+	if node.GetFirstSuccessor().Identifier.Cmp(other.info.Identifier) != 0 {
+		t.Fatalf("The successor after the merge is %v, want %v", node.GetFirstSuccessor(), other.info)
+	}
+	if other.GetPredecessor().Identifier.Cmp(node.info.Identifier) != 0 {
+		t.Fatalf("The merge doesn't notify the new successor, its predecessor is %v", other.GetPredecessor())
+	}
+	for i := 0; i < node.identifierLength; i++ {
+		if finger := node.GetFingerEntry(i); finger.Port == stale.Port {
+			t.Fatalf("The finger %d is still %v after the merge", i, finger)
+		}
+	}
+}
@@ -1,44 +1,65 @@
 package node
 
 import (
-	"crypto/tls"
+	"chord/log"
+	"context"
+	"errors"
 	"fmt"
-	"net"
 	"time"
 )
 
 const pingTimeout = 1 * time.Second
 
-// GetInfoCheck Check if the node's Info is empty or not alive
-func (nodeInfo *NodeInfo) LiveCheck() error {
-	if nodeInfo == nil {
+// LiveCheck Check if the remote node's Info is empty or not alive
+// A failed ping alone doesn't make the node dead, the failure detector of the host decides, see failureDetector.
+// But if the host answers that it doesn't know the identity, the identity is gone.
+func (remote *RemoteNode) LiveCheck() error {
+	return remote.LiveCheckContext(context.Background())
+}
+
+// LiveCheckContext is LiveCheck with a context, the check is abandoned when ctx is done.
+func (remote *RemoteNode) LiveCheckContext(ctx context.Context) error {
+	if remote.info == nil {
 		return fmt.Errorf("NodeInfo is nil")
 	}
-	if nodeInfo.Empty() {
-		return fmt.Errorf("%v is empty", nodeInfo)
+	if remote.info.Empty() {
+		return fmt.Errorf("%v is empty", remote.info)
 	}
 
-	if nodeInfo.Ping() != nil {
-		return fmt.Errorf("%v is not alive", nodeInfo)
+	err := remote.PingContext(ctx)
+	if err == nil {
+		return nil
 	}
-
+	if isServerError(err) || errors.Is(err, errIdentityMismatch) {
+		return fmt.Errorf("%v is not alive: %w", remote.info, err)
+	}
+	address := remote.info.IpAddress + ":" + remote.info.Port
+	if remote.local.host.detector.dead(address, remote.local.clock.Now(), remote.local.phiThreshold, remote.local.suspicionFailures) {
+		return fmt.Errorf("%v is not alive", remote.info)
+	}
+	log.Info("%v failed to answer, it is suspected but not declared dead yet", remote.info)
 	return nil
 }
 
-// Ping checks if the remote node can be connected.
-func (nodeInfo *NodeInfo) Ping() error {
-	address := nodeInfo.IpAddress + ":" + nodeInfo.Port
+// Ping checks if the remote node can be connected and answers.
+// With the TCP transport, it uses the pooled connection, so it doesn't cost a new handshake.
+func (remote *RemoteNode) Ping() error {
+	return remote.PingContext(context.Background())
+}
 
-	var conn net.Conn = nil
-	var err error = nil
-	if localNode.tlsBool {
-		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: pingTimeout}, "tcp", address, localNode.clientTLSConfig)
-	} else {
-		conn, err = net.DialTimeout("tcp", address, pingTimeout)
-	}
-	if err != nil {
-		return err
+// PingContext is Ping with a context, the call is abandoned when ctx is done.
+func (remote *RemoteNode) PingContext(ctx context.Context) error {
+	address := remote.info.IpAddress + ":" + remote.info.Port
+	start := remote.local.clock.Now()
+	err := remote.local.host.transport.Ping(ctx, address, serviceName(remote.info), pingTimeout, remote.peerCheck(ctx))
+	if errors.Is(err, errIdentityMismatch) {
+		return err // the peer at the address is not this node, its answers say nothing about it
 	}
-	defer conn.Close()
+	remote.observeCall(ctx, "PingRPC", start, err)
+	return err
+}
+
+// PingRPC : does nothing, it just answers
+func (handler *RPCHandler) PingRPC(args *Empty, reply *Empty) error {
 	return nil
 }
@@ -0,0 +1,221 @@
+package node
+
+import (
+	"chord/log"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCallTimeout = 10 * time.Second // the default deadline of an RPC call
+	dialTimeout        = 1 * time.Second  // the deadline to set up a connection
+	idleTimeout        = 30 * time.Second // connections unused for this long are closed
+)
+
+// idempotentMethods are the RPCs that can be sent again after the connection broke: running them twice changes nothing.
+// The others (notify, the leave notifications, the file transfers) may have run on the peer before the connection broke.
+var idempotentMethods = map[string]bool{
+	"PingRPC":                   true,
+	"HelloRPC":                  true,
+	"FindSuccessorRPC":          true,
+	"FindSuccessorRecursiveRPC": true,
+	"GetInfoRPC":                true,
+	"GetLengthRPC":              true,
+	"GetPredecessorRPC":         true,
+	"GetPredecessorsRPC":        true,
+	"GetSuccessorsRPC":          true,
+	"GetFingerTableRPC":         true,
+	"GetRingSizeRPC":            true,
+	"GetFileRPC":                true,
+	"GetAllFilesRPC":            true,
+	"GetAllBackupFilesRPC":      true,
+	"ConfirmRPC":                true,
+}
+
+// pooledClient is a connection to one peer, shared by all the calls to it.
+// rpc.Client is safe for concurrent use, so one connection per peer is enough.
+type pooledClient struct {
+	client   *rpc.Client
+	key      []byte // the public key the peer presented when the connection was set up, see peerKey
+	lastUsed time.Time
+}
+
+// connPool keeps one persistent RPC connection per peer address,
+// so we don't pay a TCP (and TLS) handshake for every call.
+type connPool struct {
+	mu      sync.Mutex
+	clients map[string]*pooledClient
+	dial    func(ctx context.Context, address string) (net.Conn, error)
+	closeCh chan struct{}
+	closed  bool
+}
+
+// newConnPool creates a pool and starts evicting its idle connections.
+func newConnPool(dial func(ctx context.Context, address string) (net.Conn, error)) *connPool {
+	pool := &connPool{
+		clients: make(map[string]*pooledClient),
+		dial:    dial,
+		closeCh: make(chan struct{}),
+	}
+	go pool.evictIdle()
+	return pool
+}
+
+// get returns the client of the address, dialing a new connection if there is none, and the key of the peer on it.
+func (pool *connPool) get(ctx context.Context, address string) (*rpc.Client, []byte, error) {
+	pool.mu.Lock()
+	if pool.closed {
+		pool.mu.Unlock()
+		return nil, nil, fmt.Errorf("connection pool is closed")
+	}
+	if pooled, ok := pool.clients[address]; ok {
+		pooled.lastUsed = time.Now()
+		pool.mu.Unlock()
+		return pooled.client, pooled.key, nil
+	}
+	pool.mu.Unlock()
+
+	// dial without holding the lock, a slow peer shouldn't block the calls to the others
+	conn, err := pool.dial(ctx, address)
+	if err != nil {
+		return nil, nil, err
+	}
+	client := rpc.NewClient(conn)
+	key := peerKey(conn)
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.closed {
+		_ = client.Close()
+		return nil, nil, fmt.Errorf("connection pool is closed")
+	}
+	if pooled, ok := pool.clients[address]; ok {
+		// someone else dialed at the same time, keep the first one
+		_ = client.Close()
+		pooled.lastUsed = time.Now()
+		return pooled.client, pooled.key, nil
+	}
+	pool.clients[address] = &pooledClient{client: client, key: key, lastUsed: time.Now()}
+	return client, key, nil
+}
+
+// drop closes the client of the address, if it is still the pooled one.
+func (pool *connPool) drop(address string, client *rpc.Client) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pooled, ok := pool.clients[address]; ok && pooled.client == client {
+		delete(pool.clients, address)
+	}
+	if err := client.Close(); err != nil && !errors.Is(err, rpc.ErrShutdown) {
+		log.Error("Error closing RPC client of %s: %v", address, err)
+	}
+}
+
+// call makes the RPC call on the pooled connection of the address, and waits at most timeout for the reply.
+// If the pooled connection turns out to be broken, it reconnects once and tries again, for the idempotent methods only.
+// Before each try, check is given the key of the peer on the connection the call goes through, a new one after a reconnect.
+// If the call times out or ctx is done, the connection is dropped, as the peer may be hung
+// and the late reply must not be written into reply after we return.
+func (pool *connPool) call(ctx context.Context, address string, method string, args interface{}, reply interface{}, timeout time.Duration, check PeerCheck) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		var client *rpc.Client
+		var key []byte
+		client, key, err = pool.get(ctx, address)
+		if err != nil {
+			return err
+		}
+		if check != nil {
+			if err := check(key); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case call := <-client.Go(method, args, reply, make(chan *rpc.Call, 1)).Done:
+			err = call.Error
+		case <-ctx.Done():
+			pool.drop(address, client)
+			return fmt.Errorf("rpc call %s to %s abandoned: %w", method, address, ctx.Err())
+		}
+
+		if !isBrokenConnection(err) {
+			return err // success, or an error returned by the remote handler
+		}
+		pool.drop(address, client)
+		if !idempotentMethods[method[strings.LastIndex(method, ".")+1:]] {
+			return err // the request may have run on the peer, the caller decides
+		}
+		log.Info("Connection to %s is broken (%v), reconnect", address, err)
+	}
+	return err
+}
+
+// isServerError tells if the error is returned by the remote handler, so the peer is alive.
+func isServerError(err error) bool {
+	var serverError rpc.ServerError
+	return errors.As(err, &serverError)
+}
+
+// isBrokenConnection tells if the error comes from the connection rather than the remote handler.
+func isBrokenConnection(err error) bool {
+	if err == nil {
+		return false
+	}
+	if isServerError(err) {
+		return false
+	}
+	return errors.Is(err, rpc.ErrShutdown) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || isNetError(err)
+}
+
+func isNetError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// evictIdle closes the connections that haven't been used for idleTimeout, until the pool is closed.
+func (pool *connPool) evictIdle() {
+	ticker := time.NewTicker(idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			pool.mu.Lock()
+			for address, pooled := range pool.clients {
+				if time.Since(pooled.lastUsed) > idleTimeout {
+					log.Info("Close idle connection to %s", address)
+					_ = pooled.client.Close()
+					delete(pool.clients, address)
+				}
+			}
+			pool.mu.Unlock()
+		case <-pool.closeCh:
+			return
+		}
+	}
+}
+
+// close closes all the connections, the pool can't be used anymore.
+func (pool *connPool) close() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if pool.closed {
+		return
+	}
+	pool.closed = true
+	close(pool.closeCh)
+	for address, pooled := range pool.clients {
+		_ = pooled.client.Close()
+		delete(pool.clients, address)
+	}
+}
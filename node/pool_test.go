@@ -0,0 +1,70 @@
+package node
+
+import (
+	"context"
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+// countingService counts the notifications it receives.
+type countingService struct {
+	notified int
+}
+
+func (service *countingService) PingRPC(args *Empty, reply *Empty) error {
+	return nil
+}
+
+func (service *countingService) NotifyRPC(args *Empty, reply *Empty) error {
+	service.notified++
+	return nil
+}
+
+func TestRetryIdempotentOnly(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	address := probe.Addr().String()
+	_ = probe.Close()
+	service := &countingService{}
+	server := rpc.NewServer()
+	if err := server.RegisterName(RPCHandlerName, service); err != nil {
+		t.Fatalf("Failed to register the service: %v", err)
+	}
+
+	first := newTCPTransport(nil, nil)
+	if err := first.Listen(address, server); err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	client := newTCPTransport(func(ctx context.Context, address string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "tcp", address)
+	}, nil)
+	t.Cleanup(client.Close)
+	if err := client.Call(context.Background(), address, RPCHandlerName+".NotifyRPC", &Empty{}, &Empty{}, time.Second, nil); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	// the peer restarts, the pooled connection is broken, stopping twice is harmless
+	first.StopListening()
+	first.StopListening()
+	second := newTCPTransport(nil, nil)
+	if err := second.Listen(address, server); err != nil {
+		t.Fatalf("Failed to listen again: %v", err)
+	}
+	t.Cleanup(second.StopListening)
+
+	// a notification is not sent again, it may have run before the connection broke
+	if err := client.Call(context.Background(), address, RPCHandlerName+".NotifyRPC", &Empty{}, &Empty{}, time.Second, nil); err == nil {
+		t.Fatalf("Notify on a broken connection succeeded, it was sent again")
+	}
+	if service.notified != 1 {
+		t.Fatalf("The service was notified %d times, want 1", service.notified)
+	}
+	// a ping is, on a new connection
+	if err := client.Ping(context.Background(), address, RPCHandlerName, time.Second, nil); err != nil {
+		t.Fatalf("Ping after the restart: %v", err)
+	}
+}
@@ -1,8 +1,8 @@
 package node
 
 import (
-	"chord/tools"
 	"fmt"
+	"time"
 )
 
 // Print the node information.
@@ -20,8 +20,8 @@ func (nodeInfo *NodeInfo) PrintInfo() {
 	)
 }
 
-func printFile(filename string) {
-	fmt.Printf("Identifier: %s, filename: %s\n", tools.GenerateIdentifier(filename).String(), filename)
+func (node *Node) printFile(filename string) {
+	fmt.Printf("Identifier: %s, filename: %s\n", node.GenerateIdentifier(filename).String(), filename)
 }
 
 // Print the files' name in the node.
@@ -32,7 +32,7 @@ func (node *Node) printFilesname() {
 	}
 	for _, filename := range filesname {
 		fmt.Printf("  ")
-		printFile(filename)
+		node.printFile(filename)
 	}
 }
 
@@ -44,19 +44,61 @@ func (node *Node) printBackupFilesname(index int) {
 	}
 	for _, filename := range filesname {
 		fmt.Printf("    ")
-		printFile(filename)
+		node.printFile(filename)
 	}
 }
 
-// PrintState prints the state (all information) of the node.
+// PrintState prints the state (all information) of the node, and of each virtual node.
 func (node *Node) PrintState() {
+	for _, identity := range node.identities() {
+		if node.virtualCount > 1 {
+			fmt.Printf("Virtual node %d/%d:\n", identity.virtualIndex, node.virtualCount)
+		}
+		identity.printIdentityState()
+	}
+	node.printRingSize()
+	node.printSessions()
+}
+
+// printRingSize prints the estimated size of the ring, and the last exact one, counted by the RINGSIZE command.
+func (node *Node) printRingSize() {
+	fmt.Printf("Ring size: about %d (estimated), lookups take at most %d steps\n", node.EstimateSize(), node.maxLookupSteps())
+	if count := node.host.lastCount.Load(); count != nil {
+		fmt.Printf("Ring size: %d (walked at %s)\n", count.size, count.at.Format(time.TimeOnly))
+	} else {
+		fmt.Println("Ring size: not walked yet")
+	}
+}
+
+// printSessions prints the protocol of the node, and the session negotiated with each peer.
+func (node *Node) printSessions() {
+	fmt.Printf("Protocol: %d (from %d), build %s, features %v, hash %s\n", ProtocolVersion, MinProtocolVersion, BuildVersion, node.features(), node.hashName)
+	fmt.Println("Sessions:")
+	addresses := node.host.sessions.addresses()
+	if len(addresses) == 0 {
+		fmt.Println("  No session")
+	}
+	for _, address := range addresses {
+		if session := node.host.sessions.get(address); session != nil {
+			fmt.Printf("  %s: %v\n", address, session)
+		}
+	}
+}
+
+// printIdentityState prints the state of one identity.
+func (node *Node) printIdentityState() {
 	fmt.Println("Self:")
 	fmt.Printf("  ")
 	node.info.PrintInfo()
 
-	fmt.Println("Predecessor:")
-	fmt.Printf("  ")
-	node.GetPredecessor().PrintInfo()
+	fmt.Printf("State: %s\n", node.GetState())
+	fmt.Printf("Intervals: %s\n", node.intervalsString())
+
+	fmt.Println("Predecessors:")
+	for i, predecessor := range node.GetPredecessors() {
+		fmt.Printf("  %d ", i)
+		predecessor.PrintInfo()
+	}
 
 	fmt.Println("Successors:")
 	for i := 0; i < node.successorsLength; i++ {
@@ -77,7 +119,54 @@ func (node *Node) PrintState() {
 	fmt.Println("Backup Files:")
 	for i := range node.backupStorages {
 		fmt.Printf("  %d: ", i)
-		node.successors[i].PrintInfo()
+		node.GetSuccessor(i).PrintInfo()
 		node.printBackupFilesname(i)
 	}
 }
+
+// shortInfo formats the node information in one short column.
+func (nodeInfo *NodeInfo) shortInfo() string {
+	if nodeInfo.Empty() {
+		return "Empty"
+	}
+	return fmt.Sprintf("%s (%s:%s)", nodeInfo.Identifier.String(), nodeInfo.IpAddress, nodeInfo.Port)
+}
+
+// Print prints the hops of the lookup as a table.
+func (trace *LookupTrace) Print() {
+	fmt.Printf("Trace of find_successor(%s): %d hops, total RTT %v\n", trace.Identifier.String(), len(trace.Hops), trace.TotalRTT())
+	fmt.Printf("  %-4s  %-40s  %-12s  %-14s  %s\n", "Step", "Node", "RTT", "Source", "Result")
+	for _, hop := range trace.Hops {
+		var result string
+		switch {
+		case hop.Err != nil:
+			result = fmt.Sprintf("error: %v", hop.Err)
+		case hop.Found:
+			result = "found " + hop.Next.shortInfo()
+		default:
+			result = "next " + hop.Next.shortInfo()
+		}
+		fmt.Printf("  %-4d  %-40s  %-12v  %-14s  %s\n", hop.Step, hop.Node.shortInfo(), hop.RTT, hop.Source, result)
+	}
+}
+
+// Print prints the ring order, the number of nodes and the problems found by the ring check.
+func (report *RingReport) Print() {
+	fmt.Println("Ring:")
+	for i, member := range report.Ring {
+		fmt.Printf("  %d %s\n", i, member.shortInfo())
+	}
+	if report.Closed {
+		fmt.Printf("%d nodes, the ring is closed\n", len(report.Ring))
+	} else {
+		fmt.Printf("%d nodes reached, the ring is not closed\n", len(report.Ring))
+	}
+	if len(report.Problems) == 0 {
+		fmt.Println("No problem found")
+		return
+	}
+	fmt.Printf("%d problems:\n", len(report.Problems))
+	for _, problem := range report.Problems {
+		fmt.Printf("  %s\n", problem)
+	}
+}
@@ -2,56 +2,260 @@ package node
 
 import (
 	"chord/log"
-	"os"
+	"chord/storage"
+	"context"
+	"crypto/sha256"
+	"fmt"
 )
 
-// Quit the node and do some cleaning work
-func (node *Node) Quit() {
+// HandoffReport tells what a graceful leave did with the node's files.
+type HandoffReport struct {
+	FilesTotal        int                          // files in the local storages when leaving
+	FilesMoved        int                          // files acknowledged by the successors
+	BackupsHandedOver bool                         // the predecessors rebuilt their backups, so the moved files are replicated again
+	HandedOff         map[string][sha256.Size]byte // the files acknowledged by the successors, by filename, with the hash of their content
+}
+
+// handedOff checks if the file was acknowledged by a successor with this content.
+func (report *HandoffReport) handedOff(file *storage.File) bool {
+	if report == nil {
+		return false
+	}
+	sum, ok := report.HandedOff[file.Key]
+	return ok && sum == sha256.Sum256(file.Value)
+}
+
+// Complete checks if every file is moved and replicated again.
+func (report *HandoffReport) Complete() bool {
+	return report.FilesMoved == report.FilesTotal && report.BackupsHandedOver
+}
+
+// Quit the node and do some cleaning work.
+// Before leaving, the files of every identity are moved to its first live successor on another host,
+// which must acknowledge them, then the predecessors rebuild their backups from these successors.
+// If the files can't be moved, the node refuses to quit and keeps running, unless force is true.
+// previous is the report of a refused quit, nil if there is none: the files it handed off are not sent again,
+// unless they changed since.
+func (node *Node) Quit(force bool, previous *HandoffReport) (*HandoffReport, error) {
 	defer log.LogFunction()()
 
-	// 1. stop the periodical tasks by closing the shutdown channel
-	node.Close()
-	// 2. notify the predecessor and successor
-	node.notifyLeave()
-	// we don't need to transfer the files to the successor,
-	// because we have the backup mechanism,
-	// the node's predecessor will send the files to the node's successors
+	report := &HandoffReport{HandedOff: make(map[string][sha256.Size]byte)}
+
+	// 1. move the files, the node doesn't accept new files from now on, they would be left behind
+	for _, identity := range node.identities() {
+		identity.leaving.Store(true)
+	}
+	successors, err := node.handoffFiles(node.ctx, report, previous)
+	if err != nil {
+		log.Error("Failed to hand off the files: %v", err)
+		if !force {
+			for _, identity := range node.identities() {
+				identity.leaving.Store(false)
+			}
+			return report, err
+		}
+		log.Info("Force to quit, %d of %d files are moved", report.FilesMoved, report.FilesTotal)
+	}
 
-	// 3. invoke os.Exit(0) to exit the node
-	os.Exit(0)
+	// 2. stop the periodical tasks, the node still serves, so the neighbors can call it back to confirm the leave, see authenticate
+	node.stopTasks()
+	// 3. notify the predecessor and successor, the predecessor takes over the backups
+	report.BackupsHandedOver = node.notifyLeave(successors)
+	// 4. stop serving, abandon the calls in progress and close the connections
+	node.host.transport.StopListening()
+	node.release()
+
+	// the process is not exited here, as other nodes may live in the same process,
+	// it is up to the caller (e.g. the cmd QUIT command) to exit
+	return report, nil
 }
 
-// stop the periodical tasks by closing the shutdown channel
+// handoffFiles moves the files of every identity to its first live successor on another host.
+// The files are only counted as moved when the successor acknowledges them, then they are marked in the report,
+// they are kept in the local storage, so a refused quit loses nothing.
+// The files the previous report handed off are counted as moved, without being sent again.
+// It returns the successor of each identity (nil if there is none), in the order of node.identities().
+func (node *Node) handoffFiles(ctx context.Context, report *HandoffReport, previous *HandoffReport) (NodeInfoList, error) {
+	defer log.LogFunction()()
+
+	var finalErr error
+	identities := node.identities()
+	successors := make(NodeInfoList, len(identities))
+	for i, identity := range identities {
+		fileList, err := identity.GetAllFiles()
+		if err != nil {
+			return successors, fmt.Errorf("failed to read the files of %v: %w", identity.info, err)
+		}
+		report.FilesTotal += len(fileList)
+		var rest storage.FileList
+		for _, file := range fileList {
+			if previous.handedOff(file) {
+				report.HandedOff[file.Key] = previous.HandedOff[file.Key]
+				report.FilesMoved++
+				continue
+			}
+			rest = append(rest, file)
+		}
+
+		successor, err := identity.handoffSuccessor(ctx)
+		if err != nil {
+			if len(rest) > 0 {
+				finalErr = err
+			}
+			continue
+		}
+		successors[i] = successor
+		if len(rest) == 0 {
+			continue
+		}
+
+		reply, err := identity.Remote(successor).StoreFilesContext(ctx, rest)
+		if err != nil || !reply.Success {
+			log.Error("%v refused the files of %v: %v", successor, identity.info, err)
+			finalErr = fmt.Errorf("%v refused the files of %v", successor, identity.info)
+			continue
+		}
+		log.Info("%d files of %v are moved to %v", len(rest), identity.info, successor)
+		for _, file := range rest {
+			report.HandedOff[file.Key] = sha256.Sum256(file.Value)
+		}
+		report.FilesMoved += len(rest)
+	}
+	return successors, finalErr
+}
+
+// handoffSuccessor returns the first live successor of the identity living on another host,
+// the virtual nodes on this host are leaving too.
+// If the successor list only holds identities of this host, the search goes on with their successor lists.
+func (node *Node) handoffSuccessor(ctx context.Context) (*NodeInfo, error) {
+	successors := node.GetSuccessors()
+	for expanded := 0; expanded < node.virtualCount; expanded++ {
+		var last *NodeInfo
+		for _, successor := range successors {
+			if successor.Empty() {
+				continue
+			}
+			if node.onThisHost(successor) {
+				last = successor
+				continue
+			}
+			if node.Remote(successor).LiveCheckContext(ctx) == nil {
+				return successor, nil
+			}
+		}
+		if last == nil || last.Identifier.Cmp(node.info.Identifier) == 0 {
+			break // no identity of this host to go on with, or we are back to the node
+		}
+		var err error
+		if successors, err = node.Remote(last).GetSuccessorsContext(ctx); err != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("%v has no live successor on another host", node.info)
+}
+
+// onThisHost checks if the node info is the node itself or one of its virtual nodes.
+func (node *Node) onThisHost(nodeInfo *NodeInfo) bool {
+	return nodeInfo.IpAddress == node.info.IpAddress && nodeInfo.Port == node.info.Port
+}
+
+// Close stops the node without notifying anyone, like a crash.
 func (node *Node) Close() {
-	close(node.shutdownCh)
+	node.stopServing()
+	node.release()
+}
+
+// stop the periodical tasks, stop the listener if it is started, and close the served connections
+func (node *Node) stopServing() {
+	node.stopTasks()
+	node.host.transport.StopListening()
+}
+
+// stop the periodical tasks of every identity by closing the shutdown channels
+func (node *Node) stopTasks() {
+	for _, identity := range node.identities() {
+		identity.stopOnce.Do(func() { close(identity.shutdownCh) })
+	}
+}
+
+// release abandons the calls in progress, so the periodic tasks stop promptly, and closes the transport
+func (node *Node) release() {
+	for _, identity := range node.identities() {
+		identity.cancel()
+	}
+	node.host.transport.Close()
 }
 
-// Notify the node's predecessor and successor it is leaving the ring.
-// Only invoked by the quit function, and should close the listener before calling this function.
-func (node *Node) notifyLeave() {
+// Notify the predecessor and successor of every identity that it is leaving the ring.
+// Only invoked by the quit function, and should stop the periodical tasks before calling this function,
+// but not the listener: the neighbors call the node back to confirm the notifications.
+// successors are the successors of the identities on other hosts, found by handoffFiles.
+// It returns true if every predecessor took over the backups.
+func (node *Node) notifyLeave(successors NodeInfoList) bool {
 	defer log.LogFunction()()
 
-	// The method below don't have return value
-	// notify the predecessor to update its successor list
-	node.GetPredecessor().NotifyPredecessor()
+	handedOver := true
+	for i, identity := range node.identities() {
+		if !identity.notifyIdentityLeave(successors[i]) {
+			handedOver = false
+		}
+	}
+	return handedOver
+}
+
+// notifyIdentityLeave notifies the predecessor and successor of this identity only.
+// The neighbors on this host are leaving too, they are not notified.
+func (node *Node) notifyIdentityLeave(successor *NodeInfo) bool {
+	if successor == nil {
+		log.Info("No successor on another host, no one to notify")
+		return true
+	}
+
+	// notify the predecessor to replace the node with its successor, and to rebuild its backups, and wait for it
+	handedOver := true
+	predecessor := node.GetPredecessor()
+	if predecessor.Empty() || node.onThisHost(predecessor) {
+		log.Info("No predecessor on another host to take over the backups")
+	} else if err := node.Remote(predecessor).NotifyPredecessorContext(node.ctx, successor); err != nil {
+		log.Error("%v failed to take over the backups: %v", predecessor, err)
+		handedOver = false
+	}
 	// notify the successor to update its predecessor, you can send your predecessor to it
-	node.GetFirstSuccessor().NotifySuccessor(node.GetPredecessor())
+	node.Remote(successor).NotifySuccessorContext(node.ctx, predecessor)
+	return handedOver
 }
 
 // NotifySuccessorLeave : Notify the node that its successor is leaving
-func (node *Node) NotifySuccessorLeave() {
+func (node *Node) NotifySuccessorLeave(successor *NodeInfo) error {
 	// for the node, its successor is leaving, this successor views the node as its predecessor
-	// this successor won't give any Information to the node, instead, the node will should update the successor list itself
-	_ = node.updateReplica()
+	// this successor gives its own successor to the node, so the ring stays connected even if r is 1
+	// the successor has already moved its files to it, so the backups rebuilt here contain them
+	node.forgetLeavingPeer(node.GetFirstSuccessor())
+	if err := node.Remote(successor).LiveCheckContext(node.ctx); err != nil {
+		log.Info("NotifySuccessorLeaveRPC's arg successor: %v, update the successor list itself", err)
+	} else {
+		node.SetFirstSuccessor(successor)
+	}
+	return node.updateReplica(node.ctx)
+}
+
+// forgetLeavingPeer tells the failure detector that the peer is leaving, it is dead as soon as it doesn't answer,
+// instead of being suspected for a while.
+func (node *Node) forgetLeavingPeer(peer *NodeInfo) {
+	if peer.Empty() || node.onThisHost(peer) {
+		return
+	}
+	node.host.detector.forget(peer.IpAddress + ":" + peer.Port)
 }
 
 // NotifyPredecessorLeave : Notify the node that its predecessor is leaving
 func (node *Node) NotifyPredecessorLeave(predecessor *NodeInfo) {
 	// for the node, its predecessor is leaving, this predecessor views the node as its successor
 	// this predecessor will give its predecessor to the node, so the node can update its predecessor
+	node.forgetLeavingPeer(node.GetPredecessor())
 
 	// and we need to check the predecessor
-	if err := predecessor.LiveCheck(); err != nil {
+	if err := node.Remote(predecessor).LiveCheckContext(node.ctx); err != nil {
 		log.Info("NotifyPredecessorLeaveRPC's arg predecessor: %v, do nothing", err)
 		return
 	}
@@ -64,37 +268,79 @@ func (node *Node) NotifyPredecessorLeave(predecessor *NodeInfo) {
 // NotifyPredecessor A wrap of NotifySuccessorLeave method.
 // Notify the predecessor that its successor is leaving.
 // But this function is invoked locally, for the node itself, it's notifying the predecessor.
-// Don't need return value.
-func (nodeInfo *NodeInfo) NotifyPredecessor() {
-	_ = nodeInfo.callRPC("NotifySuccessorLeaveRPC", &Empty{}, &Empty{})
+// It returns when the predecessor has rebuilt its successor list and backups, which acknowledges the handover.
+func (remote *RemoteNode) NotifyPredecessor(successor *NodeInfo) error {
+	return remote.NotifyPredecessorContext(context.Background(), successor)
+}
+
+// NotifyPredecessorContext is NotifyPredecessor with a context, the call is abandoned when ctx is done.
+func (remote *RemoteNode) NotifyPredecessorContext(ctx context.Context, successor *NodeInfo) error {
+	return remote.callRPC(ctx, "NotifySuccessorLeaveRPC", remote.leaveArgs("NotifySuccessorLeaveRPC", successor), &Empty{})
 }
 
 // NotifySuccessorLeaveRPC : Notify the node that its successor is leaving
-func (handler *RPCHandler) NotifySuccessorLeaveRPC(args *Empty, reply *Empty) error {
+// Only the host of the successor can say it is leaving, see authenticateLeave.
+func (handler *RPCHandler) NotifySuccessorLeaveRPC(args *LeaveArgs, reply *Empty) error {
 	defer log.LogFunction()()
-	// Empty reply, don't need the caller to wait for the reply,
-	// so we can use the asyncHandleRPC function to handle the RPC logic
-	asyncHandleRPC(func() {
-		localNode.NotifySuccessorLeave()
-	})
-	return nil
+	if err := handler.node.authenticateLeave("NotifySuccessorLeaveRPC", args, handler.node.GetFirstSuccessor()); err != nil {
+		return err
+	}
+	// the leaving node waits for the reply, it is the acknowledgment that the backups are taken over
+	return handler.node.NotifySuccessorLeave(args.nodeInfo())
 }
 
 // NotifySuccessor A wrap of NotifyPredecessorLeave method.
 // Notify the successor that its predecessor is leaving.
 // But this function is invoked locally, for the node itself, it's notifying the successor.
 // Don't need return value.
-func (nodeInfo *NodeInfo) NotifySuccessor(predecessor *NodeInfo) {
-	_ = nodeInfo.callRPC("NotifyPredecessorLeaveRPC", predecessor, &Empty{})
+func (remote *RemoteNode) NotifySuccessor(predecessor *NodeInfo) {
+	remote.NotifySuccessorContext(context.Background(), predecessor)
+}
+
+// NotifySuccessorContext is NotifySuccessor with a context, the call is abandoned when ctx is done.
+func (remote *RemoteNode) NotifySuccessorContext(ctx context.Context, predecessor *NodeInfo) {
+	_ = remote.callRPC(ctx, "NotifyPredecessorLeaveRPC", remote.leaveArgs("NotifyPredecessorLeaveRPC", predecessor), &Empty{})
 }
 
 // NotifyPredecessorLeaveRPC : Notify the node that its predecessor is leaving
-func (handler *RPCHandler) NotifyPredecessorLeaveRPC(predecessor *NodeInfo, reply *Empty) error {
+// Only the host of the predecessor can say it is leaving, see authenticateLeave.
+func (handler *RPCHandler) NotifyPredecessorLeaveRPC(args *LeaveArgs, reply *Empty) error {
 	defer log.LogFunction()()
-	asyncHandleRPC(func() {
-		localNode.NotifyPredecessorLeave(predecessor)
+	if err := handler.node.authenticateLeave("NotifyPredecessorLeaveRPC", args, handler.node.GetPredecessor()); err != nil {
+		return err
+	}
+	handler.node.asyncHandleRPC(func() {
+		handler.node.NotifyPredecessorLeave(args.nodeInfo())
 	})
 	return nil
 }
 
+// leaveArgs returns the signed args of a leave notification, handing over the neighbor.
+func (remote *RemoteNode) leaveArgs(method string, neighbor *NodeInfo) *LeaveArgs {
+	return &LeaveArgs{
+		Identifier: neighbor.Identifier,
+		IpAddress:  neighbor.IpAddress,
+		Port:       neighbor.Port,
+		Signature:  remote.local.sign(method, remote.info, infoPayload(neighbor)),
+	}
+}
+
+// nodeInfo returns the neighbor handed over by the leave notification.
+func (args *LeaveArgs) nodeInfo() *NodeInfo {
+	return &NodeInfo{Identifier: args.Identifier, IpAddress: args.IpAddress, Port: args.Port}
+}
+
+// authenticateLeave checks the signature of a leave notification, and that the signer lives on the host of
+// the neighbor that leaves: with virtual nodes, the notification may come from another identity of that host.
+func (node *Node) authenticateLeave(method string, args *LeaveArgs, leaving *NodeInfo) error {
+	if err := node.authenticate(method, &args.Signature, infoPayload(args.nodeInfo())); err != nil {
+		return err
+	}
+	if !sameHostAs(&args.Signature.Signer, leaving) {
+		log.Error("Rejected %s: %v is not on the host of %v", method, args.Signature.Signer, leaving)
+		return fmt.Errorf("%s refused by %v: %w: %v is not on the host of %v", method, node.info, errUnauthenticated, args.Signature.Signer, leaving)
+	}
+	return nil
+}
+
 /*                             RPC Part                             */
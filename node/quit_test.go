@@ -0,0 +1,47 @@
+package node
+
+import (
+	"chord/storage"
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+func TestQuitSkipsHandedOffFiles(t *testing.T) {
+	network := NewMemoryNetwork(1)
+	successor := startTestNode(t, network, "4182", nil)
+	leaving := startTestNode(t, network, "4183", successor)
+	waitForRing(t, []*Node{successor, leaving}, 10*time.Second)
+
+	files := storage.FileList{
+		{Key: "moved", Value: []byte("a")},
+		{Key: "changed", Value: []byte("b")},
+		{Key: "left", Value: []byte("c")},
+	}
+	if err := leaving.StoreFiles(files); err != nil {
+		t.Fatalf("Failed to store the files: %v", err)
+	}
+	// a refused quit handed off two files, one of them changed since
+	previous := &HandoffReport{HandedOff: map[string][sha256.Size]byte{
+		"moved":   sha256.Sum256([]byte("a")),
+		"changed": sha256.Sum256([]byte("old")),
+	}}
+	// the backups are left out, the handoff of the files is what counts here
+	report, err := leaving.Quit(false, previous)
+	if err != nil || report.FilesMoved != report.FilesTotal {
+		t.Fatalf("Quit: report %+v, error %v", report, err)
+	}
+	if len(report.HandedOff) != len(files) {
+		t.Fatalf("The report marks %d files as handed off, want %d", len(report.HandedOff), len(files))
+	}
+
+	// only the files not handed off yet are sent
+	if _, err := successor.GetFile("moved"); err == nil {
+		t.Fatalf("The file handed off before is sent again")
+	}
+	for _, filename := range []string{"changed", "left"} {
+		if _, err := successor.GetFile(filename); err != nil {
+			t.Fatalf("The successor doesn't have the file %s: %v", filename, err)
+		}
+	}
+}
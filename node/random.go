@@ -0,0 +1,45 @@
+package node
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// random is the source of the random choices of a node, safe for its goroutines.
+// A simulation seeds it, see WithSeed, so the same seed gives the same choices.
+type random struct {
+	mu     sync.Mutex
+	source *rand.Rand
+}
+
+func newRandom(seed int64) *random {
+	return &random{source: rand.New(rand.NewSource(seed))}
+}
+
+// Intn returns a number in [0, n).
+func (random *random) Intn(n int) int {
+	random.mu.Lock()
+	defer random.mu.Unlock()
+	return random.source.Intn(n)
+}
+
+// Int63n returns a number in [0, n).
+func (random *random) Int63n(n int64) int64 {
+	random.mu.Lock()
+	defer random.mu.Unlock()
+	return random.source.Int63n(n)
+}
+
+// Float64 returns a number in [0.0, 1.0).
+func (random *random) Float64() float64 {
+	random.mu.Lock()
+	defer random.mu.Unlock()
+	return random.source.Float64()
+}
+
+// Shuffle shuffles the n elements, see rand.Shuffle.
+func (random *random) Shuffle(n int, swap func(i, j int)) {
+	random.mu.Lock()
+	defer random.mu.Unlock()
+	random.source.Shuffle(n, swap)
+}
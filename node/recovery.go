@@ -0,0 +1,114 @@
+package node
+
+import (
+	"chord/log"
+	"chord/tools"
+	"context"
+	"fmt"
+	"math/big"
+)
+
+// RingState tells if the node is part of a ring, or is trying to get back into one.
+type RingState string
+
+const (
+	StateJoined    RingState = "joined"    // the node has a live successor
+	StateIsolated  RingState = "isolated"  // all the successors are dead, the node is a ring on its own
+	StateRejoining RingState = "rejoining" // the node is trying the candidates to rejoin the ring
+)
+
+// becomeIsolated is called when all the successors are dead.
+// The node becomes a ring on its own, so it keeps serving its data, and the next stabilization tries to rejoin.
+func (node *Node) becomeIsolated() {
+	log.Info("%v is isolated, it will try to rejoin the ring", node.info)
+	node.setState(StateIsolated)
+	node.SetFirstSuccessor(&node.info)
+}
+
+// rejoin looks for the first live node after the node among the candidates, and takes it as the successor.
+// The candidates are the finger table entries and the predecessor, which may still be alive on our side,
+// then the distant peers remembered by the node, then the bootstrap peers. Each candidate is also asked for the successor of the node,
+// as the candidates only know a part of the ring. The stabilization refines the successor afterwards.
+func (node *Node) rejoin(ctx context.Context) error {
+	defer log.LogFunction()()
+
+	node.setState(StateRejoining)
+	var best *NodeInfo
+	consider := func(nodeInfo *NodeInfo) {
+		if nodeInfo.Identifier.Cmp(node.info.Identifier) == 0 {
+			return
+		}
+		if best == nil || tools.LessThan(node.distance(node.info.Identifier, nodeInfo.Identifier), node.distance(node.info.Identifier, best.Identifier)) {
+			best = nodeInfo
+		}
+	}
+	for _, candidate := range node.rejoinCandidates(ctx) {
+		if err := node.Remote(candidate).LiveCheckContext(ctx); err != nil {
+			log.Info("Rejoin candidate %v: %v", candidate, err)
+			continue
+		}
+		consider(candidate)
+		if successor, err := node.lookupFrom(ctx, candidate); err == nil {
+			consider(successor)
+		}
+	}
+	if best == nil {
+		node.setState(StateIsolated)
+		return fmt.Errorf("%v failed to rejoin the ring, no candidate works", node.info)
+	}
+
+	node.SetFirstSuccessor(best)
+	node.setState(StateJoined)
+	log.Info("%v rejoined the ring, its successor is %v", node.info, best)
+	return nil
+}
+
+// rejoinCandidates returns the nodes that may lead the node back into the ring, in order of preference,
+// the ones on this host are left out.
+func (node *Node) rejoinCandidates(ctx context.Context) NodeInfoList {
+	var candidates NodeInfoList
+	seen := make(map[string]bool)
+	add := func(candidate *NodeInfo) {
+		if candidate.Empty() || node.onThisHost(candidate) || seen[candidate.Identifier.String()] {
+			return
+		}
+		seen[candidate.Identifier.String()] = true
+		candidates = append(candidates, candidate)
+	}
+
+	for i := node.identifierLength - 1; i >= 0; i-- {
+		add(node.GetFingerEntry(i))
+	}
+	add(node.GetPredecessor())
+	for _, peer := range node.peerMemory.list() {
+		add(peer)
+	}
+
+	// the bootstrap peers are only known by their address, in random order so the nodes don't all pick the same one
+	peers := append(NodeInfoList{}, node.bootstrapPeers...)
+	node.random.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	for _, peer := range peers {
+		peerInfo, err := node.Remote(peer).GetNodeInfoContext(ctx)
+		if err != nil {
+			log.Info("Bootstrap peer %s:%s is not reachable: %v", peer.IpAddress, peer.Port, err)
+			continue
+		}
+		add(peerInfo)
+	}
+	return candidates
+}
+
+// lookupFrom asks the candidate for the first live node after the node.
+// The candidate's ring may still list the node, so it asks for the successor of n+1, not of n.
+func (node *Node) lookupFrom(ctx context.Context, candidate *NodeInfo) (*NodeInfo, error) {
+	next := new(big.Int).Add(node.info.Identifier, big.NewInt(1))
+	node.mod(next)
+	successor, err := node.Remote(candidate).Lookup(ctx, next)
+	if err != nil {
+		return nil, err
+	}
+	if err := node.Remote(successor).LiveCheckContext(ctx); err != nil {
+		return nil, err
+	}
+	return successor, nil
+}
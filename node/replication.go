@@ -4,19 +4,19 @@ import (
 	"chord/log"
 	"chord/storage"
 	"chord/tools"
+	"context"
 	"fmt"
-	"os"
 )
 
 // All r successors would have to simultaneously fail in order to disrupt the Chord ring,
 // an event that can be made very improbable with modest values of r.
 // @Return: the index of the first live successor and the error
-func (node *Node) findFirstLiveSuccessor() (int, error) {
+func (node *Node) findFirstLiveSuccessor(ctx context.Context) (int, error) {
 	defer log.LogFunction()()
 
 	for index := 0; index < node.successorsLength; index++ {
 		successor := node.GetSuccessor(index)
-		if successor.LiveCheck() == nil {
+		if node.Remote(successor).LiveCheckContext(ctx) == nil {
 			node.SetFirstSuccessor(successor) // set it immediately
 			log.Info("Successor[%d]: Node %v is alive, set as successors[0]", index, successor)
 			return index, nil
@@ -27,15 +27,15 @@ func (node *Node) findFirstLiveSuccessor() (int, error) {
 
 // Helper function for stabilize(), now used in updateSuccessors()
 // used to handle the successor's predecessor
-func (node *Node) handleX() {
+func (node *Node) handleX(ctx context.Context) {
 	log.Info("Execute successor's predecessor")
 	successor := node.GetFirstSuccessor()
-	x, err := successor.GetPredecessor() // x = successor.predecessor
+	x, err := node.Remote(successor).GetPredecessorContext(ctx) // x = successor.predecessor
 	if err != nil {
 		log.Error("Failed to get the successor's predecessor")
 		return
 	}
-	if err := x.LiveCheck(); err != nil {
+	if err := node.Remote(x).LiveCheckContext(ctx); err != nil {
 		log.Info("successor's predecessor, aka x: %v", err)
 		return // it's ok if x is dead, we simply don't need to update the successor[0]!
 	}
@@ -52,37 +52,75 @@ func (node *Node) handleX() {
 // Update successors of the node.
 // Node n reconciles its list with its successor s by copying s's successor list, removing its last entry, and prepending s to it.
 // If node n notices that its successor has failed, it replaces it with the first live entry in its successor list and reconciles its successor list with its new successor.
-func (node *Node) updateSuccessors() error {
+// The entries living on the same physical host as n (its virtual nodes) are skipped, except s itself,
+// so the replicas land on other hosts, and the list is padded with empty entries.
+// With IdPolicySecure, the entries whose identifier is not derived from the key of their address are skipped too.
+// @Return: for each entry of the new list, the index of the entry in s's list (-1 if it's s or an empty entry),
+// which is used to pick the backup files of the entry from s's backups.
+func (node *Node) updateSuccessors(ctx context.Context) ([]int, error) {
 	defer log.LogFunction()()
 
 	successor := node.GetFirstSuccessor()
 
 	// 1. get this successor's successor list
-	sSuccessors, err := successor.GetSuccessors()
+	sSuccessors, err := node.Remote(successor).GetSuccessorsContext(ctx)
 	if err != nil {
 		log.Error("Failed to get the successor's successors")
-		return err
+		return nil, err
 	}
 	lenSSuccessors := len(sSuccessors)
 	if lenSSuccessors != node.successorsLength {
 		log.Error("Strange, successor's successors is not equal to the node's SuccessorsLength")
-		return fmt.Errorf("successor's successors is not equal to the node's SuccessorsLength")
+		return nil, fmt.Errorf("successor's successors is not equal to the node's SuccessorsLength")
 	}
 	log.Info("Successfully get the successor's successors")
 	log.Info("sSuccessors:")
 	PrintNodeList(sSuccessors)
 
 	// 2. reconcile (update) the node's successor list
-	//  1) we just need SuccessorsLength-1 items
-	truncatedSSuccessors := (sSuccessors)[:node.successorsLength-1]
-	// 	2) prepend s to the list
-	nSuccessors := append(NodeInfoList{successor}, truncatedSSuccessors...)
+	// 	1) prepend s to the list
+	nSuccessors := NodeInfoList{successor}
+	sources := []int{-1}
+	//  2) we just need SuccessorsLength-1 items, skip the ones on the same host
+	for j := 0; j < lenSSuccessors && len(nSuccessors) < node.successorsLength; j++ {
+		if node.sameHost(sSuccessors[j]) {
+			log.Info("%v lives on the same host, skip it", sSuccessors[j])
+			continue
+		}
+		if node.forgedIdentity(ctx, sSuccessors[j]) {
+			log.Error("%v is not the node at its address, skip it", sSuccessors[j])
+			continue
+		}
+		nSuccessors = append(nSuccessors, sSuccessors[j])
+		sources = append(sources, j)
+	}
+	for len(nSuccessors) < node.successorsLength {
+		nSuccessors = append(nSuccessors, NewNodeInfo())
+		sources = append(sources, -1)
+	}
 	// 	3) reconcile its successor list with its new successor
 	node.SetSuccessors(nSuccessors)
 	log.Info("nSuccessors:")
 	PrintNodeList(nSuccessors)
 
-	return nil
+	return sources, nil
+}
+
+// sameHostIdentity returns the identity of the host the node info is, if it lives on the same host as the node, nil otherwise.
+func (node *Node) sameHostIdentity(nodeInfo *NodeInfo) *Node {
+	if !node.sameHost(nodeInfo) {
+		return nil
+	}
+	return node.host.node(nodeInfo)
+}
+
+// sameHost checks if the node info is another identity living on the same physical host as the node.
+// The node itself is not counted, in a small ring it may appear in its own successor list.
+func (node *Node) sameHost(nodeInfo *NodeInfo) bool {
+	if nodeInfo.Empty() {
+		return false
+	}
+	return node.onThisHost(nodeInfo) && nodeInfo.Identifier.Cmp(node.info.Identifier) != 0
 }
 
 func (node *Node) DeleteAllBackupFiles() error {
@@ -96,10 +134,10 @@ func (node *Node) DeleteAllBackupFiles() error {
 	return nil
 }
 
-func (node *Node) GetSuccessorFiles() (storage.FileList, error) {
+func (node *Node) GetSuccessorFiles(ctx context.Context) (storage.FileList, error) {
 	successor := node.GetFirstSuccessor()
 
-	sFilesReply, err := successor.GetAllFiles()
+	sFilesReply, err := node.Remote(successor).GetAllFilesContext(ctx)
 	if err != nil {
 		log.Error("%v.GetAllFiles() call failed: %v", successor, err)
 		return nil, err
@@ -114,10 +152,10 @@ func (node *Node) GetSuccessorFiles() (storage.FileList, error) {
 	return sFileList, nil
 }
 
-func (node *Node) GetSuccessorBackupFiles() ([]storage.FileList, error) {
+func (node *Node) GetSuccessorBackupFiles(ctx context.Context) ([]storage.FileList, error) {
 	successor := node.GetFirstSuccessor()
 
-	sBackupFilesReply, err := successor.GetAllBackupFiles()
+	sBackupFilesReply, err := node.Remote(successor).GetAllBackupFilesContext(ctx)
 	if err != nil {
 		log.Error("%v.GetAllBackupFiles() call failed: %v", successor, err)
 		return nil, err
@@ -142,13 +180,16 @@ func (node *Node) GetSuccessorBackupFiles() ([]storage.FileList, error) {
 // If we can't stay consistent, then we need to clear the relevant backup storages on the local disk.
 //  1. if we can't get the successor[0]'s files, then we can't do the following steps, and we need to clear all the backup files on the local disk
 //  2. if we can't get the successor[0]'s all backup files, then we need to log it, and record the error, but we can still do the following steps, because we have already got the successor[0]'s files, and we can place them into backupStorages[0]
-func (node *Node) updateBackupFiles() error {
+//
+// sources is returned by updateSuccessors, successors[i] is the sources[i]-th successor of successor[0],
+// so its files are in successor[0]'s backupStorages[sources[i]].
+func (node *Node) updateBackupFiles(ctx context.Context, sources []int) error {
 	defer log.LogFunction()()
 
 	var finalErr error
 
 	// 1. get successor[0]'s files
-	sFileList, err := node.GetSuccessorFiles()
+	sFileList, err := node.GetSuccessorFiles(ctx)
 	if err != nil {
 		// if we can't get the successor[0]'s files, then we can't do the following steps
 		// and we need to clear all the backup files on the local disk, otherwise the backup files will be inconsistent with the successors
@@ -162,7 +203,7 @@ func (node *Node) updateBackupFiles() error {
 	var nFileLists []storage.FileList
 
 	// 2. get successor[0]'s all backup files
-	backupFileLists, err := node.GetSuccessorBackupFiles()
+	backupFileLists, err := node.GetSuccessorBackupFiles(ctx)
 	if err != nil {
 		// if we can't get the successor[0]'s all backup files,
 		// we need to log it, and record the error,
@@ -174,10 +215,22 @@ func (node *Node) updateBackupFiles() error {
 		log.Info("Successfully get the successor[0]'s all backup files")
 
 		// 3. if we can get the successor[0]'s all backup files, then we need to reconcile the node's backup files
-		//  we just need IdentifierLength-1 items
-		truncatedBackupFileLists := backupFileLists[:node.successorsLength-1] // keep in mind that in go, the slice [start, end] is [start, end)
-		// 	prepend sFileList to the list
-		nFileLists = append([]storage.FileList{sFileList}, truncatedBackupFileLists...)
+		// 	sFileList first, then the backups of the successors we kept
+		nFileLists = []storage.FileList{sFileList}
+		for _, j := range sources[1:] {
+			if j < 0 {
+				nFileLists = append(nFileLists, nil) // empty entry, nothing to back up
+				continue
+			}
+			nFileLists = append(nFileLists, backupFileLists[j])
+		}
+	}
+	// a successor on the same host would be backed up on the host that holds the files, which doesn't survive it
+	// so they are not stored, GetAllBackupFiles reads them from the identity for the predecessors on other hosts
+	for i, successor := range node.GetSuccessors() {
+		if i < len(nFileLists) && node.sameHost(successor) {
+			nFileLists[i] = nil
+		}
 	}
 	log.Info("nFileLists:")
 	PrintFileLists(nFileLists)
@@ -200,10 +253,10 @@ func (node *Node) updateBackupFiles() error {
 
 // Send the old backup files to the new successor.
 // It will only be called when the first successor is dead and oldBackupFileList is not empty.
-func (node *Node) sendBackupFiles(oldBackupFileList storage.FileList) error {
+func (node *Node) sendBackupFiles(ctx context.Context, oldBackupFileList storage.FileList) error {
 	log.Info("The first successor is dead, oldBackupFileList is not empty, send it to the new successor")
 	successor := node.GetFirstSuccessor()
-	reply, err := successor.StoreFiles(oldBackupFileList)
+	reply, err := node.Remote(successor).StoreFilesContext(ctx, oldBackupFileList)
 	if err != nil {
 		log.Error("%v.StoreFiles(oldBackupFileList) call failed: %v", successor, err)
 		return err
@@ -217,17 +270,21 @@ func (node *Node) sendBackupFiles(oldBackupFileList storage.FileList) error {
 }
 
 // Update both successors and backup files of the node.
-func (node *Node) updateReplica() error {
+// All the calls are abandoned when ctx is done, e.g. when the node is shutting down.
+func (node *Node) updateReplica(ctx context.Context) error {
 	defer log.LogFunction()()
 
 	// check if the first successor is alive or not
 	// it will determine whether we need to send the old backup files to the new successor
 	// at the same time, we will find the first live successor
-	indexOfFirstLiveSuccessor, err := node.findFirstLiveSuccessor()
+	indexOfFirstLiveSuccessor, err := node.findFirstLiveSuccessor(ctx)
 	firstSuccessorIsDead := indexOfFirstLiveSuccessor != 0
 	if err != nil {
 		log.Error("Failed to find the first live successor: %v", err)
-		os.Exit(1) // all successors are dead, the node should exit
+		if ctx.Err() == nil {
+			node.becomeIsolated() // all successors are dead, the node goes on alone and tries to rejoin
+		}
+		return err
 	}
 
 	var oldBackupFileList storage.FileList
@@ -246,13 +303,13 @@ func (node *Node) updateReplica() error {
 	// now we have the successors[0] alive
 	// deal with the successor's predecessor, aka x
 	// it may change the successor[0] to x, if x (alive) is in (node, successor[0])
-	node.handleX()
+	node.handleX(ctx)
 
 	// from this time, we truly have the successor[0] ready for use
 
 	// 1. if the first successor is dead, then we need to send these old backup files to the new successor
 	if firstSuccessorIsDead && oldBackupFileList != nil && len(oldBackupFileList) > 0 {
-		if err := node.sendBackupFiles(oldBackupFileList); err != nil {
+		if err := node.sendBackupFiles(ctx, oldBackupFileList); err != nil {
 			log.Error("Failed to send the backup files to the new successor: %v", err)
 			// if this send call fails, then we need to store these old backup files to the node's storage
 			// so that the new successor can get them later through notifying (the node), and the node will send them again!
@@ -265,7 +322,8 @@ func (node *Node) updateReplica() error {
 	}
 
 	// 2. update successors
-	if err := node.updateSuccessors(); err != nil {
+	sources, err := node.updateSuccessors(ctx)
+	if err != nil {
 		// this function will only fail if we can't get the successor's successors
 		// theoretically, it should not happen, because we have already checked the first live successor
 		// but if it happens, then the node's successor list will just remain the same (not updated)
@@ -276,7 +334,7 @@ func (node *Node) updateReplica() error {
 	}
 
 	// 3. update backup files
-	if err := node.updateBackupFiles(); err != nil {
+	if err := node.updateBackupFiles(ctx, sources); err != nil {
 		return err
 	} else {
 		log.Info("Successfully update backup files")
@@ -0,0 +1,53 @@
+package node
+
+import (
+	"chord/tools"
+	"testing"
+	"time"
+)
+
+func TestBackupsOnOtherHosts(t *testing.T) {
+	network := NewMemoryNetwork(1)
+	host := startTestNode(t, network, "4180", nil, WithVirtualNodes(2))
+	other := startTestNode(t, network, "4181", host)
+	identities := host.identities()
+
+	// in a ring of three, one identity of the host follows the other one
+	predecessor, successor := identities[0], identities[1]
+	if tools.ModIntervalCheck(other.info.Identifier, predecessor.info.Identifier, successor.info.Identifier, false, false) {
+		predecessor, successor = successor, predecessor
+	}
+	waitFor(t, "the ring to stabilize", func() bool {
+		return predecessor.GetFirstSuccessor().Identifier.Cmp(successor.info.Identifier) == 0 &&
+			successor.GetFirstSuccessor().Identifier.Cmp(other.info.Identifier) == 0 &&
+			other.GetFirstSuccessor().Identifier.Cmp(predecessor.info.Identifier) == 0
+	})
+	if err := successor.StoreFile("file", []byte("data")); err != nil {
+		t.Fatalf("Failed to store the file: %v", err)
+	}
+
+	// the other host backs the file up, as the second successor of its successor
+	waitFor(t, "the other host to back the file up", func() bool {
+		fileList, err := other.backupStorages[1].GetAllFiles()
+		return err == nil && len(fileList) == 1 && fileList[0].Key == "file"
+	})
+	// the host holding the file doesn't back it up for its other identity
+	fileList, err := predecessor.backupStorages[0].GetAllFiles()
+	if err != nil {
+		t.Fatalf("Failed to read the backup files: %v", err)
+	}
+	if len(fileList) != 0 {
+		t.Fatalf("The backup of a successor on the same host holds %v, want nothing", fileList)
+	}
+}
+
+// waitFor waits until the condition holds, it fails the test after 10s.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,36 @@
+package node
+
+import (
+	"chord/log"
+	"context"
+	"fmt"
+)
+
+// maxRingWalk limits the number of nodes visited by walkRing, in case the ring is broken and never comes back.
+const maxRingWalk = 4096
+
+// walkRing follows the first successors from start until it comes back to start.
+// It returns the members in ring order, start first.
+// It costs one RPC per member, so it should only be used for rare operations.
+func (node *Node) walkRing(ctx context.Context, start *NodeInfo) (NodeInfoList, error) {
+	defer log.LogFunction()()
+
+	members := NodeInfoList{start}
+	current := start
+	for i := 0; i < maxRingWalk; i++ {
+		successors, err := node.Remote(current).GetSuccessorsContext(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%v.GetSuccessors() failed: %w", current, err)
+		}
+		if len(successors) == 0 || successors[0].Empty() {
+			return nil, fmt.Errorf("%v has no successor", current)
+		}
+		next := successors[0]
+		if next.Identifier.Cmp(start.Identifier) == 0 {
+			return members, nil
+		}
+		members = append(members, next)
+		current = next
+	}
+	return nil, fmt.Errorf("the walk didn't come back to %v within %d steps", start, maxRingWalk)
+}
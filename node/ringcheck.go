@@ -0,0 +1,208 @@
+package node
+
+import (
+	"chord/log"
+	"chord/tools"
+	"context"
+	"fmt"
+)
+
+// RingReport is the outcome of CheckRing.
+type RingReport struct {
+	Ring     NodeInfoList // the nodes met by the walk, in ring order, the local node first
+	Closed   bool         // whether the walk came back to the local node
+	Problems []string     // the broken links, loops, disagreeing successor lists and stale fingers
+}
+
+// Healthy tells if the walk came back to the local node without any problem.
+func (report *RingReport) Healthy() bool {
+	return report.Closed && len(report.Problems) == 0
+}
+
+func (report *RingReport) problem(format string, args ...interface{}) {
+	report.Problems = append(report.Problems, fmt.Sprintf(format, args...))
+}
+
+// CheckRing walks the ring from the node through the first successors, and checks along the way that:
+//  1. the predecessor of each node's successor points back to the node.
+//  2. the successor list of each node is its successor followed by the successor's list, see updateSuccessors.
+//  3. the walk comes back to the node, without looping on a part of the ring.
+//  4. each finger entry of each node is what an independent FindSuccessorIter from the node finds.
+//
+// It costs a few RPCs per node and a lookup per distinct finger, so it is a tool for the operators and the tests.
+// The problems are collected in the report, the walk only stops when it can't go on.
+func (node *Node) CheckRing(ctx context.Context) *RingReport {
+	defer log.LogFunction()()
+
+	report := &RingReport{Ring: NodeInfoList{&node.info}}
+	successorLists := make(map[string]NodeInfoList)
+	seen := map[string]int{nodeKey(&node.info): 0}
+
+	current := &node.info
+	for len(report.Ring) <= maxRingWalk {
+		successors, err := node.Remote(current).GetSuccessorsContext(ctx)
+		if err != nil {
+			report.problem("%s is unreachable, the walk stops: %v", current.shortInfo(), err)
+			break
+		}
+		successorLists[nodeKey(current)] = successors
+		if len(successors) == 0 || successors[0].Empty() {
+			report.problem("%s has no successor, the walk stops", current.shortInfo())
+			break
+		}
+		next := successors[0]
+
+		predecessor, err := node.Remote(next).GetPredecessorContext(ctx)
+		if err != nil {
+			report.problem("broken link %s -> %s: the successor is unreachable: %v", current.shortInfo(), next.shortInfo(), err)
+		} else if !sameNode(predecessor, current) {
+			report.problem("broken link %s -> %s: the predecessor of %s is %s",
+				current.shortInfo(), next.shortInfo(), next.shortInfo(), predecessor.shortInfo())
+		}
+
+		if index, ok := seen[nodeKey(next)]; ok {
+			if index == 0 {
+				report.Closed = true
+			} else {
+				report.problem("loop: %s goes back to %s instead of %s", current.shortInfo(), next.shortInfo(), node.info.shortInfo())
+			}
+			break
+		}
+		seen[nodeKey(next)] = len(report.Ring)
+		report.Ring = append(report.Ring, next)
+		current = next
+	}
+	if !report.Closed && len(report.Ring) > maxRingWalk {
+		report.problem("the walk didn't come back to %s within %d nodes", node.info.shortInfo(), maxRingWalk)
+	}
+
+	node.checkSuccessorLists(report, successorLists)
+	node.checkFingers(ctx, report)
+	return report
+}
+
+// nodeKey identifies a node, its identifier alone is not enough when the ring has a collision.
+func nodeKey(nodeInfo *NodeInfo) string {
+	return nodeInfo.Identifier.String() + "@" + nodeInfo.IpAddress + ":" + nodeInfo.Port
+}
+
+// checkSuccessorLists checks that the list of each node agrees with the list of its successor.
+func (node *Node) checkSuccessorLists(report *RingReport, successorLists map[string]NodeInfoList) {
+	for _, member := range report.Ring {
+		successors := successorLists[nodeKey(member)]
+		if len(successors) == 0 || successors[0].Empty() {
+			continue
+		}
+		sSuccessors, ok := successorLists[nodeKey(successors[0])]
+		if !ok {
+			continue // the walk didn't reach it
+		}
+		want := expectedSuccessors(member, successors[0], sSuccessors, len(successors))
+		for i := range want {
+			if !sameNode(successors[i], want[i]) {
+				report.problem("the successor list of %s disagrees with its successor %s: entry %d is %s, want %s",
+					member.shortInfo(), successors[0].shortInfo(), i, successors[i].shortInfo(), want[i].shortInfo())
+				break
+			}
+		}
+	}
+}
+
+// expectedSuccessors is the successor list that updateSuccessors builds on owner from its successor's list:
+// the successor, then the successor's list without the other identities of owner's host, padded with empty entries.
+func expectedSuccessors(owner *NodeInfo, successor *NodeInfo, sSuccessors NodeInfoList, length int) NodeInfoList {
+	want := NodeInfoList{successor}
+	for _, entry := range sSuccessors {
+		if len(want) == length {
+			break
+		}
+		sameHost := !entry.Empty() && entry.IpAddress == owner.IpAddress && entry.Port == owner.Port &&
+			entry.Identifier.Cmp(owner.Identifier) != 0
+		if !sameHost {
+			want = append(want, entry)
+		}
+	}
+	for len(want) < length {
+		want = append(want, NewNodeInfo())
+	}
+	return want
+}
+
+// checkFingers compares the finger tables of the ring members with lookups made from the node.
+// A finger whose ideal identifier falls before the owner found for the previous one has the same owner,
+// so it reuses the answer instead of a lookup, like initFingerTable.
+// With proximity routing, finger[i] may be any ring member in [n+2^i, n+2^(i+1)) when the lookup lands there,
+// see proximityFinger.
+func (node *Node) checkFingers(ctx context.Context, report *RingReport) {
+	members := make(map[string]bool, len(report.Ring))
+	for _, member := range report.Ring {
+		members[nodeKey(member)] = true
+	}
+	for _, member := range report.Ring {
+		fingers, err := node.Remote(member).GetFingerTableContext(ctx)
+		if err != nil {
+			report.problem("can't get the finger table of %s: %v", member.shortInfo(), err)
+			continue
+		}
+		var previous *NodeInfo
+		for i, finger := range fingers {
+			identifier := node.fingerEntryId(member, i)
+			want := previous
+			if want == nil || !tools.ModIntervalCheck(identifier, member.Identifier, want.Identifier, false, true) {
+				want, err = node.Remote(&node.info).FindSuccessorIterContext(ctx, identifier)
+				if err != nil {
+					report.problem("find_successor(%s) for finger %d of %s failed: %v", identifier.String(), i, member.shortInfo(), err)
+					previous = nil
+					continue
+				}
+			}
+			previous = want
+			if !sameNode(finger, want) && !(members[nodeKey(finger)] && node.proximityInterval(member, i, want, finger)) {
+				report.problem("finger %d of %s is %s, but find_successor(%s) is %s",
+					i, member.shortInfo(), finger.shortInfo(), identifier.String(), want.shortInfo())
+			}
+		}
+	}
+}
+
+// proximityInterval tells if both the owner of n+2^i and the finger are in [n+2^i, n+2^(i+1)),
+// where proximityFinger may choose any node for finger[i].
+func (node *Node) proximityInterval(member *NodeInfo, i int, want *NodeInfo, finger *NodeInfo) bool {
+	if finger.Empty() {
+		return false
+	}
+	start := node.fingerEntryId(member, i)
+	end := member.Identifier // the last interval ends at n itself
+	if i+1 < node.identifierLength {
+		end = node.fingerEntryId(member, i+1)
+	}
+	return tools.ModIntervalCheck(want.Identifier, start, end, true, false) &&
+		tools.ModIntervalCheck(finger.Identifier, start, end, true, false)
+}
+
+/*                             RPC Part                             */
+
+// GetFingerTable A wrap of GetFingerTableRPC method, call it and return the reply and error originally
+func (remote *RemoteNode) GetFingerTable() (NodeInfoList, error) {
+	return remote.GetFingerTableContext(context.Background())
+}
+
+// GetFingerTableContext is GetFingerTable with a context, the call is abandoned when ctx is done.
+func (remote *RemoteNode) GetFingerTableContext(ctx context.Context) (NodeInfoList, error) {
+	reply := NodeInfoList{}
+	err := remote.callRPC(ctx, "GetFingerTableRPC", &Empty{}, &reply)
+	return reply, err
+}
+
+// GetFingerTableRPC : get the node's finger table, an entry not filled yet is empty
+func (handler *RPCHandler) GetFingerTableRPC(args *Empty, reply *NodeInfoList) error {
+	fingers := make(NodeInfoList, handler.node.identifierLength)
+	for i := range fingers {
+		fingers[i] = handler.node.GetFingerEntry(i)
+		if fingers[i] == nil {
+			fingers[i] = NewNodeInfo()
+		}
+	}
+	*reply = fingers
+	return nil
+}
@@ -0,0 +1,43 @@
+package node
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCheckRing(t *testing.T) {
+	network := NewMemoryNetwork(1)
+	var nodes []*Node
+	for i := 0; i < 5; i++ {
+		var joinNode *Node
+		if i > 0 {
+			joinNode = nodes[0]
+		}
+		nodes = append(nodes, startTestNode(t, network, strconv.Itoa(4170+i), joinNode))
+	}
+	waitForRing(t, nodes, 10*time.Second)
+
+	// the fingers converge after the successors
+	var report *RingReport
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		report = nodes[0].CheckRing(nodes[0].ctx)
+		if report.Healthy() {
+			break
+		}
+	}
+	if !report.Healthy() || len(report.Ring) != len(nodes) {
+		t.Fatalf("Ring check of a converged ring: %d nodes, closed %t, problems %v", len(report.Ring), report.Closed, report.Problems)
+	}
+
+	// a node cut off from the others breaks a link, the check must notice it at once
+	var others []string
+	for _, node := range append(nodes[:3:3], nodes[4]) {
+		others = append(others, "127.0.0.1:"+node.info.Port)
+	}
+	network.Partition(others, []string{"127.0.0.1:" + nodes[3].info.Port})
+	report = nodes[0].CheckRing(nodes[0].ctx)
+	if report.Healthy() {
+		t.Fatalf("Ring check passed with an unreachable node, ring %v", report.Ring)
+	}
+}
@@ -2,94 +2,140 @@ package node
 
 import (
 	"chord/log"
-	"crypto/tls"
+	"context"
+	"errors"
 	"fmt"
-	"net"
-	"net/rpc"
 	"os"
+	"time"
 )
 
 // RPCHandler is the RPC handler for Chord node communication.
 // It is safer to use handler rather than use node itself, as we don't want to expose the node's internal functions.
-type RPCHandler int
+// Each handler is bound to one node, so several nodes can live in the same process.
+type RPCHandler struct {
+	node *Node
+}
+
+const RPCHandlerName = "RPCHandler"
+
+// RemoteNode is a remote node seen from a local node.
+// All the RPC wraps are defined on it, so every call uses the TLS and transport settings of the local node that makes it.
+type RemoteNode struct {
+	info  *NodeInfo // the node to call
+	local *Node     // the node that makes the call
+}
+
+// Remote returns the RemoteNode used by the node to call nodeInfo.
+func (node *Node) Remote(nodeInfo *NodeInfo) *RemoteNode {
+	return &RemoteNode{info: nodeInfo, local: node}
+}
+
+// Info returns the NodeInfo of the remote node.
+func (remote *RemoteNode) Info() *NodeInfo {
+	return remote.info
+}
 
-const RPCHandlerPrefix = "RPCHandler."
+// address returns the ip:port of the remote node.
+func (remote *RemoteNode) address() string {
+	return remote.info.IpAddress + ":" + remote.info.Port
+}
+
+// serviceName is the name under which the handler of the identity is registered.
+// A node may host several identities on one port, so the calls are routed by the identifier.
+// A NodeInfo without identifier (e.g. the join node, only known by its address) reaches the primary identity.
+func serviceName(nodeInfo *NodeInfo) string {
+	if nodeInfo.Empty() {
+		return RPCHandlerName
+	}
+	return RPCHandlerName + "@" + nodeInfo.Identifier.String()
+}
 
 // startServer starts the rpc server for the node.
-// Use TLS if `node.TLSBool` is true, otherwise use normal TCP.
-// The RPCHandler will be:
-//  1. registered as an RPC server.
-//  2. isten on the port specified in the node's Info.
-//  3. serve RPC requests in a separate goroutine.
+// The RPCHandler of each identity (the node and its virtual nodes) will be:
+//  1. registered in the host's RPC server.
+//  2. reachable through the transport at the address of the node's Info,
+//     TCP listens on its port and uses TLS if `node.TLSBool` is true.
+//     With IdPolicySecure, the TLS handshake asks the peers for their certificate, see secureTLSConfigs.
 func (node *Node) startServer() {
 	log.Logger.Print(log.CenterTitle("Listen port and RPC server", "="))
 	defer log.Logger.Print(log.CenterTitle("Listen port and RPC server", "="))
 
-	handler := new(RPCHandler)
-	if err := rpc.Register(handler); err != nil {
+	// the primary identity is also reachable by the address only
+	if err := node.host.server.RegisterName(RPCHandlerName, &RPCHandler{node: node}); err != nil {
 		fmt.Println("Failed to register RPC server:", err)
 		os.Exit(1)
 	}
-
-	var listener net.Listener = nil
-	var err error = nil
-	if node.tlsBool {
-		listener, err = tls.Listen("tcp", ":"+node.info.Port, node.serverTLSConfig)
-	} else {
-		listener, err = net.Listen("tcp", ":"+node.info.Port)
+	for _, identity := range node.identities() {
+		if err := node.host.server.RegisterName(serviceName(&identity.info), &RPCHandler{node: identity}); err != nil {
+			fmt.Println("Failed to register RPC server:", err)
+			os.Exit(1)
+		}
 	}
-	if err != nil {
+
+	if err := node.host.transport.Listen(node.info.IpAddress+":"+node.info.Port, node.host.server); err != nil {
 		fmt.Printf("Worker %s failed to listen: %v\n", node.info.Port, err)
 		os.Exit(1)
 	}
 	fmt.Printf("Node %s listening on %s\n", node.info.Identifier.String(), node.info.Port)
+}
 
-	go func() {
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				log.Info("Failed to accept connection: %v", err)
-				continue
-			}
-			go rpc.ServeConn(conn)
-		}
-	}()
+// callRPC makes an RPC call to the remote node, through the transport of the local node.
+// The call fails if there is no reply within the local node's call timeout, or if ctx is done before.
+func (remote *RemoteNode) callRPC(ctx context.Context, method string, args interface{}, reply interface{}) error {
+	return remote.callRPCWithTimeout(ctx, method, args, reply, remote.local.callTimeout)
 }
 
-// callRPC makes an RPC call to the node.
-func (nodeInfo *NodeInfo) callRPC(method string, args interface{}, reply interface{}) error {
-	rpcMethod := RPCHandlerPrefix + method
-	address := nodeInfo.IpAddress + ":" + nodeInfo.Port
-
-	var conn net.Conn = nil
-	var err error = nil
-	if localNode.tlsBool {
-		conn, err = tls.Dial("tcp", address, localNode.clientTLSConfig)
-	} else {
-		conn, err = net.Dial("tcp", address)
-	}
+// callRPCWithTimeout is callRPC with a specific timeout.
+// With IdPolicySecure, the call is only sent if the identifier of the remote node is the one of the peer on the connection, see peerCheck.
+func (remote *RemoteNode) callRPCWithTimeout(ctx context.Context, method string, args interface{}, reply interface{}, timeout time.Duration) error {
+	return remote.callRPCWithCheck(ctx, method, args, reply, timeout, remote.peerCheck(ctx))
+}
+
+// callRPCWithCheck is callRPCWithTimeout with a specific check of the peer, e.g. one recording its key, see Handshake.
+func (remote *RemoteNode) callRPCWithCheck(ctx context.Context, method string, args interface{}, reply interface{}, timeout time.Duration, check PeerCheck) error {
+	rpcMethod := serviceName(remote.info) + "." + method
+	address := remote.info.IpAddress + ":" + remote.info.Port
+
+	start := remote.local.clock.Now()
+	err := remote.local.host.transport.Call(ctx, address, rpcMethod, args, reply, timeout, check)
 	if err != nil {
-		log.Error("Dialing %s failed: %v", address, err)
-		return err
+		log.Error("Error in RPC call %s to %s: %v", rpcMethod, address, err)
 	}
+	if errors.Is(err, errIdentityMismatch) {
+		return err // the peer at the address is not this node, its answers say nothing about it
+	}
+	remote.observeCall(ctx, method, start, err)
+	return err
+}
 
-	client := rpc.NewClient(conn)
-	defer func() {
-		if err := client.Close(); err != nil {
-			log.Error("Error closing RPC client: %v", err)
+// observeCall feeds the outcome of a call to the failure detector and the RTT estimate of the peer.
+func (remote *RemoteNode) observeCall(ctx context.Context, method string, start time.Time, err error) {
+	address := remote.info.IpAddress + ":" + remote.info.Port
+	if err != nil {
+		if isServerError(err) {
+			remote.local.host.detector.heartbeat(address, remote.local.clock.Now()) // the peer answered, with an error
+		} else if ctx.Err() == nil {
+			remote.local.host.detector.failure(address)
+			remote.local.observeChurn() // the peer may be gone
+			// the peer may come back with another build, the next call negotiates again
+			remote.local.host.sessions.forget(address)
 		}
-	}()
-
-	if err := client.Call(rpcMethod, args, reply); err != nil {
-		log.Error("Error in RPC call: %v", rpcMethod, err)
-		return err
+		return
+	}
+	remote.local.host.detector.heartbeat(address, remote.local.clock.Now())
+	if rttMethods[method] {
+		remote.local.host.latency.observe(address, remote.local.clock.Now().Sub(start))
 	}
-	return nil
 }
 
 // asyncHandleRPC abstracts the common logic for handling RPC calls with empty replies asynchronously.
 // We could simply use a goroutine in the RPC func, but we emphasize the asynchronous procedure here.
-func asyncHandleRPC(handler func()) {
+// With WithManualScheduling, the handler runs before the reply, so the caller knows when it is done.
+func (node *Node) asyncHandleRPC(handler func()) {
+	if node.manualScheduling {
+		handler()
+		return
+	}
 	go func() {
 		handler()
 	}()
@@ -2,6 +2,7 @@ package node
 
 import (
 	"chord/storage"
+	"math/big"
 )
 
 /*                             basic part                             */
@@ -15,11 +16,54 @@ type BoolReply struct {
 
 /*                             basic part                             */
 
+/*                             stabilize part                             */
+
+type NotifyArgs struct {
+	Predecessor  NodeInfo     // the node that may be the predecessor
+	Predecessors NodeInfoList // its own predecessors, so the notified node can rebuild its predecessor list
+	Signature    Signature    // signed by the predecessor, see authenticate
+}
+
+// LeaveArgs is the neighbor handed over by a leaving node, and the signature of the leaving node.
+// It has the fields of NodeInfo, so the nodes from before the signatures still decode it.
+type LeaveArgs struct {
+	Identifier *big.Int
+	IpAddress  string
+	Port       string
+	Signature  Signature // signed by the leaving node, see authenticate
+}
+
+// Signature authenticates a membership message with the node key of its sender, see sign.
+type Signature struct {
+	Signer    NodeInfo // the identity that sends the message
+	PublicKey []byte   // the node key of the signer, DER encoded, its identifier is derived from it
+	Time      int64    // when the message was signed, in unix nanoseconds, so it can't be replayed much later
+	Sig       []byte
+}
+
+// ConfirmArgs asks the claimed sender of a membership message if it sent it, without IdPolicySecure, see confirmSender.
+type ConfirmArgs struct {
+	Digest []byte // the digest of the message, see messageDigest
+}
+
+type ConfirmReply struct {
+	Sent bool     // the identity sent the message
+	Info NodeInfo // the identity that answers, compared with the claimed sender
+}
+
+/*                             stabilize part                             */
+
 /*                             find part                             */
 
 type FindSuccessorReply struct {
 	Found    bool
 	NodeInfo NodeInfo
+	Source   RouteSource // where the NodeInfo comes from, used by the lookup trace
+}
+
+type FindSuccessorRecursiveArgs struct {
+	Identifier *big.Int
+	Hops       int // hops left before giving up
 }
 
 /*                             find part                             */
@@ -33,7 +77,8 @@ type StoreFileArgs struct {
 type StoreFileReply = BoolReply
 
 type StoreFileListArgs struct {
-	FileList storage.FileList
+	FileList  storage.FileList
+	Signature Signature // signed by the node that hands the files over, see authenticate
 }
 
 type StoreFileListReply = BoolReply
@@ -70,4 +115,13 @@ type GetLengthReply struct {
 	SuccessorsLength int
 }
 
+type RingSizeArgs struct {
+	Exact bool // walk the ring to count its nodes, one RPC per node, at most ringWalkTimeout
+}
+
+type RingSizeReply struct {
+	Estimate int // from the density of the successor and predecessor lists
+	Exact    int // the number of nodes met by the walk, 0 if not asked
+}
+
 /*                             other                             */
@@ -1,54 +1,96 @@
 package node
 
+import (
+	"context"
+)
+
 // GetLength A wrap of GetLengthRPC method, call it and return the reply and error originally
-func (nodeInfo *NodeInfo) GetLength() (*GetLengthReply, error) {
+func (remote *RemoteNode) GetLength() (*GetLengthReply, error) {
+	return remote.GetLengthContext(context.Background())
+}
+
+// GetLengthContext is GetLength with a context, the call is abandoned when ctx is done.
+func (remote *RemoteNode) GetLengthContext(ctx context.Context) (*GetLengthReply, error) {
 	reply := &GetLengthReply{}
-	err := nodeInfo.callRPC("GetLengthRPC", &Empty{}, reply)
+	err := remote.callRPC(ctx, "GetLengthRPC", &Empty{}, reply)
 	return reply, err
 }
 
 // GetLengthRPC : get the node's Info
 func (handler *RPCHandler) GetLengthRPC(args *Empty, reply *GetLengthReply) error {
-	reply.IdentifierLength = localNode.identifierLength
-	reply.SuccessorsLength = localNode.successorsLength
+	reply.IdentifierLength = handler.node.identifierLength
+	reply.SuccessorsLength = handler.node.successorsLength
 	return nil
 }
 
 // GetNodeInfo A wrap of GetInfoRPC method, call it and return the reply and error originally
-func (nodeInfo *NodeInfo) GetNodeInfo() (*NodeInfo, error) {
+func (remote *RemoteNode) GetNodeInfo() (*NodeInfo, error) {
+	return remote.GetNodeInfoContext(context.Background())
+}
+
+// GetNodeInfoContext is GetNodeInfo with a context, the call is abandoned when ctx is done.
+func (remote *RemoteNode) GetNodeInfoContext(ctx context.Context) (*NodeInfo, error) {
 	reply := &NodeInfo{}
-	err := nodeInfo.callRPC("GetInfoRPC", &Empty{}, reply)
+	err := remote.callRPC(ctx, "GetInfoRPC", &Empty{}, reply)
 	return reply, err
 }
 
 // GetInfoRPC : get the node's Info
 func (handler *RPCHandler) GetInfoRPC(args *Empty, reply *NodeInfo) error {
-	*reply = localNode.info
+	*reply = handler.node.info
 	return nil
 }
 
 // GetPredecessor A wrap of GetPredecessorRPC method, call it and return the reply and error originally
-func (nodeInfo *NodeInfo) GetPredecessor() (*NodeInfo, error) {
+func (remote *RemoteNode) GetPredecessor() (*NodeInfo, error) {
+	return remote.GetPredecessorContext(context.Background())
+}
+
+// GetPredecessorContext is GetPredecessor with a context, the call is abandoned when ctx is done.
+func (remote *RemoteNode) GetPredecessorContext(ctx context.Context) (*NodeInfo, error) {
 	reply := &NodeInfo{}
-	err := nodeInfo.callRPC("GetPredecessorRPC", &Empty{}, reply)
+	err := remote.callRPC(ctx, "GetPredecessorRPC", &Empty{}, reply)
 	return reply, err
 }
 
 // GetPredecessorRPC : get the node's predecessor
 func (handler *RPCHandler) GetPredecessorRPC(args *Empty, reply *NodeInfo) error {
-	*reply = *localNode.GetPredecessor()
+	*reply = *handler.node.GetPredecessor()
+	return nil
+}
+
+// GetPredecessors A wrap of GetPredecessorsRPC method, call it and return the reply and error originally
+func (remote *RemoteNode) GetPredecessors() (NodeInfoList, error) {
+	return remote.GetPredecessorsContext(context.Background())
+}
+
+// GetPredecessorsContext is GetPredecessors with a context, the call is abandoned when ctx is done.
+func (remote *RemoteNode) GetPredecessorsContext(ctx context.Context) (NodeInfoList, error) {
+	reply := NodeInfoList{}
+	err := remote.callRPC(ctx, "GetPredecessorsRPC", &Empty{}, &reply)
+	return reply, err
+}
+
+// GetPredecessorsRPC : get the node's predecessors
+func (handler *RPCHandler) GetPredecessorsRPC(args *Empty, reply *NodeInfoList) error {
+	*reply = handler.node.GetPredecessors()
 	return nil
 }
 
 // GetSuccessors A wrap of GetSuccessorsRPC method, call it and return the reply and error originally
-func (nodeInfo *NodeInfo) GetSuccessors() (NodeInfoList, error) {
+func (remote *RemoteNode) GetSuccessors() (NodeInfoList, error) {
+	return remote.GetSuccessorsContext(context.Background())
+}
+
+// GetSuccessorsContext is GetSuccessors with a context, the call is abandoned when ctx is done.
+func (remote *RemoteNode) GetSuccessorsContext(ctx context.Context) (NodeInfoList, error) {
 	reply := NodeInfoList{}
-	err := nodeInfo.callRPC("GetSuccessorsRPC", &Empty{}, &reply)
+	err := remote.callRPC(ctx, "GetSuccessorsRPC", &Empty{}, &reply)
 	return reply, err
 }
 
 // GetSuccessorsRPC : get the node's successors
 func (handler *RPCHandler) GetSuccessorsRPC(args *Empty, reply *NodeInfoList) error {
-	*reply = localNode.GetSuccessors()
+	*reply = handler.node.GetSuccessors()
 	return nil
 }
@@ -3,12 +3,19 @@ package node
 import (
 	"chord/log"
 	"chord/storage"
+	"context"
+	"fmt"
 )
 
 /*                             single file part                             */
 
 // StoreFile is a wrap of StoreFileRPC method
-func (nodeInfo *NodeInfo) StoreFile(filename string, fileContent []byte) (*StoreFileReply, error) {
+func (remote *RemoteNode) StoreFile(filename string, fileContent []byte) (*StoreFileReply, error) {
+	return remote.StoreFileContext(context.Background(), filename, fileContent)
+}
+
+// StoreFileContext is StoreFile with a context, the call is abandoned when ctx is done.
+func (remote *RemoteNode) StoreFileContext(ctx context.Context, filename string, fileContent []byte) (*StoreFileReply, error) {
 	file := storage.File{
 		Key:   filename,
 		Value: fileContent,
@@ -17,7 +24,7 @@ func (nodeInfo *NodeInfo) StoreFile(filename string, fileContent []byte) (*Store
 		File: file,
 	}
 	reply := &StoreFileReply{}
-	err := nodeInfo.callRPC("StoreFileRPC", args, reply)
+	err := remote.callRPC(ctx, "StoreFileRPC", args, reply)
 	return reply, err
 }
 
@@ -26,8 +33,13 @@ func (handler *RPCHandler) StoreFileRPC(args *StoreFileArgs, reply *StoreFileRep
 	defer log.LogFunction()()
 
 	file := args.File
+	if handler.node.leaving.Load() {
+		log.Info("The node is leaving, refuse to store %s", file.Key)
+		reply.Success = false
+		return nil
+	}
 
-	err := localNode.StoreFile(file.Key, file.Value)
+	err := handler.node.StoreFile(file.Key, file.Value)
 	if err != nil {
 		reply.Success = false
 	} else {
@@ -37,13 +49,18 @@ func (handler *RPCHandler) StoreFileRPC(args *StoreFileArgs, reply *StoreFileRep
 }
 
 // GetFile is a wrap of GetFileRPC method
-// get the file from the node (nodeInfo)
-func (nodeInfo *NodeInfo) GetFile(filename string) (*GetFileReply, error) {
+// get the file from the remote node
+func (remote *RemoteNode) GetFile(filename string) (*GetFileReply, error) {
+	return remote.GetFileContext(context.Background(), filename)
+}
+
+// GetFileContext is GetFile with a context, the call is abandoned when ctx is done.
+func (remote *RemoteNode) GetFileContext(ctx context.Context, filename string) (*GetFileReply, error) {
 	args := &GetFileArgs{
 		Filename: filename,
 	}
 	reply := &GetFileReply{}
-	err := nodeInfo.callRPC("GetFileRPC", args, reply)
+	err := remote.callRPC(ctx, "GetFileRPC", args, reply)
 	return reply, err
 }
 
@@ -51,7 +68,7 @@ func (nodeInfo *NodeInfo) GetFile(filename string) (*GetFileReply, error) {
 func (handler *RPCHandler) GetFileRPC(args *GetFileArgs, reply *GetFileReply) error {
 	defer log.LogFunction()()
 
-	fileContent, err := localNode.GetFile(args.Filename)
+	fileContent, err := handler.node.GetFile(args.Filename)
 	if err != nil {
 		reply.Success = false
 		reply.FileContent = nil
@@ -67,9 +84,14 @@ func (handler *RPCHandler) GetFileRPC(args *GetFileArgs, reply *GetFileReply) er
 /*                             multiple files part                             */
 
 // GetAllFiles is a wrap of GetAllFilesRPC method
-func (nodeInfo *NodeInfo) GetAllFiles() (*GetFileListReply, error) {
+func (remote *RemoteNode) GetAllFiles() (*GetFileListReply, error) {
+	return remote.GetAllFilesContext(context.Background())
+}
+
+// GetAllFilesContext is GetAllFiles with a context, the call is abandoned when ctx is done.
+func (remote *RemoteNode) GetAllFilesContext(ctx context.Context) (*GetFileListReply, error) {
 	reply := &GetFileListReply{}
-	err := nodeInfo.callRPC("GetAllFilesRPC", &Empty{}, reply)
+	err := remote.callRPC(ctx, "GetAllFilesRPC", &Empty{}, reply)
 	return reply, err
 }
 
@@ -78,7 +100,7 @@ func (nodeInfo *NodeInfo) GetAllFiles() (*GetFileListReply, error) {
 func (handler *RPCHandler) GetAllFilesRPC(args *Empty, reply *GetFileListReply) error {
 	defer log.LogFunction()()
 
-	if fileList, err := localNode.GetAllFiles(); err != nil {
+	if fileList, err := handler.node.GetAllFiles(); err != nil {
 		log.Error("GetAllFiles() failed: %v", err)
 		reply.Success = false
 		reply.FileList = nil
@@ -91,9 +113,14 @@ func (handler *RPCHandler) GetAllFilesRPC(args *Empty, reply *GetFileListReply)
 }
 
 // GetAllBackupFiles is a wrap of GetAllBackupFilesRPC method
-func (nodeInfo *NodeInfo) GetAllBackupFiles() (*GetFileListsReply, error) {
+func (remote *RemoteNode) GetAllBackupFiles() (*GetFileListsReply, error) {
+	return remote.GetAllBackupFilesContext(context.Background())
+}
+
+// GetAllBackupFilesContext is GetAllBackupFiles with a context, the call is abandoned when ctx is done.
+func (remote *RemoteNode) GetAllBackupFilesContext(ctx context.Context) (*GetFileListsReply, error) {
 	reply := &GetFileListsReply{}
-	err := nodeInfo.callRPC("GetAllBackupFilesRPC", &Empty{}, reply)
+	err := remote.callRPC(ctx, "GetAllBackupFilesRPC", &Empty{}, reply)
 	return reply, err
 }
 
@@ -101,7 +128,7 @@ func (nodeInfo *NodeInfo) GetAllBackupFiles() (*GetFileListsReply, error) {
 func (handler *RPCHandler) GetAllBackupFilesRPC(args *Empty, reply *GetFileListsReply) error {
 	defer log.LogFunction()()
 
-	if fileLists, err := localNode.GetAllBackupFiles(); err != nil {
+	if fileLists, err := handler.node.GetAllBackupFiles(); err != nil {
 		log.Error("GetAllBackupFiles() failed: %v", err)
 		reply.Success = false
 		reply.FileLists = nil
@@ -118,20 +145,37 @@ func (handler *RPCHandler) GetAllBackupFilesRPC(args *Empty, reply *GetFileLists
 //
 //  1. The node's successor[0] failed, the node needs to send the backup file list to its new successor.
 //  2. A new node join the ring and becomes the node's new predecessor, the node needs to send the chosen file list to it. (file's identifier <= predecessor)
-func (nodeInfo *NodeInfo) StoreFiles(fileList storage.FileList) (*StoreFileListReply, error) {
+func (remote *RemoteNode) StoreFiles(fileList storage.FileList) (*StoreFileListReply, error) {
+	return remote.StoreFilesContext(context.Background(), fileList)
+}
+
+// StoreFilesContext is StoreFiles with a context, the call is abandoned when ctx is done.
+func (remote *RemoteNode) StoreFilesContext(ctx context.Context, fileList storage.FileList) (*StoreFileListReply, error) {
 	args := &StoreFileListArgs{
-		FileList: fileList,
+		FileList:  fileList,
+		Signature: remote.local.sign("StoreFilesRPC", remote.info, filesPayload(fileList)),
 	}
 	reply := &StoreFileListReply{}
-	err := nodeInfo.callRPC("StoreFilesRPC", args, reply)
+	err := remote.callRPC(ctx, "StoreFilesRPC", args, reply)
 	return reply, err
 }
 
 // StoreFilesRPC : Store the file list on the node's storage
+// The files are only taken from the host of a neighbor, see authenticateFiles.
 func (handler *RPCHandler) StoreFilesRPC(args *StoreFileListArgs, reply *StoreFileListReply) error {
 	defer log.LogFunction()()
 
-	if err := localNode.StoreFiles(args.FileList); err != nil {
+	if err := handler.node.authenticateFiles(args); err != nil {
+		reply.Success = false
+		return err
+	}
+
+	if handler.node.leaving.Load() {
+		log.Info("The node is leaving, refuse to store %d files", len(args.FileList))
+		reply.Success = false
+		return nil
+	}
+	if err := handler.node.StoreFiles(args.FileList); err != nil {
 		log.Error("StoreFiles failed: %v", err)
 		reply.Success = false
 	} else {
@@ -141,4 +185,21 @@ func (handler *RPCHandler) StoreFilesRPC(args *StoreFileListArgs, reply *StoreFi
 	return nil
 }
 
+// authenticateFiles checks the signature of a file transfer, and that the signer lives on the host of a neighbor:
+// the files come from a predecessor (leaving, or whose successor died) or from a successor (handing over the keys of a new predecessor).
+func (node *Node) authenticateFiles(args *StoreFileListArgs) error {
+	if err := node.authenticate("StoreFilesRPC", &args.Signature, filesPayload(args.FileList)); err != nil {
+		return err
+	}
+	neighbors := append(NodeInfoList{node.GetPredecessor()}, node.GetPredecessors()...)
+	neighbors = append(neighbors, node.GetSuccessors()...)
+	for _, neighbor := range neighbors {
+		if sameHostAs(&args.Signature.Signer, neighbor) {
+			return nil
+		}
+	}
+	log.Error("Rejected StoreFilesRPC: %v is not on the host of a neighbor", args.Signature.Signer)
+	return fmt.Errorf("StoreFilesRPC refused by %v: %w: %v is not on the host of a neighbor", node.info, errUnauthenticated, args.Signature.Signer)
+}
+
 /*                             multiple files part                             */
@@ -0,0 +1,193 @@
+package node
+
+import (
+	"bytes"
+	"chord/log"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// maxVirtualIndex is the highest index of an identity on a physical node, the -vnodes limit minus one.
+const maxVirtualIndex = 63
+
+// errIdentityMismatch marks a peer whose identifier is not derived from the key it presents,
+// retrying can't fix it: either the NodeInfo is forged, or another process took the address.
+var errIdentityMismatch = errors.New("identifier does not match the key of the peer")
+
+// keyIdentifier is the identifier of the k-th identity of the node owning the public key, with IdPolicySecure.
+// The primary identity is the hash of the key, the others the hash of key#k, like the addresses of IdPolicyHash.
+func (node *Node) keyIdentifier(key []byte, k int) *big.Int {
+	if k == 0 {
+		return node.GenerateIdentifier(string(key))
+	}
+	return node.GenerateIdentifier(fmt.Sprintf("%s#%d", key, k))
+}
+
+// identityMatchesKey tells if the identifier of the NodeInfo is one of the count identities of the key,
+// count being the number of identities its host announces, see outgoingSession.
+func (node *Node) identityMatchesKey(nodeInfo *NodeInfo, key []byte, count int) bool {
+	for k := 0; k < count; k++ {
+		if node.keyIdentifier(key, k).Cmp(nodeInfo.Identifier) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// certificateKey returns the public key of the first certificate of the TLS configuration, DER encoded,
+// the key a node with IdPolicySecure derives its identifiers from.
+func certificateKey(tlsConfig *tls.Config) ([]byte, error) {
+	if tlsConfig == nil || len(tlsConfig.Certificates) == 0 || len(tlsConfig.Certificates[0].Certificate) == 0 {
+		return nil, fmt.Errorf("the secure identifier policy needs a TLS certificate")
+	}
+	certificate, err := x509.ParseCertificate(tlsConfig.Certificates[0].Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the TLS certificate: %w", err)
+	}
+	return certificate.RawSubjectPublicKeyInfo, nil
+}
+
+// secureTLSConfigs returns the TLS configurations of a node with IdPolicySecure:
+// the server asks every peer for its certificate, and the client presents the node's one,
+// so both sides of a connection know the key of the other.
+func secureTLSConfigs(serverTLSConfig, clientTLSConfig *tls.Config) (*tls.Config, *tls.Config) {
+	server := serverTLSConfig.Clone()
+	server.ClientAuth = tls.RequireAnyClientCert
+	server.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 || len(state.PeerCertificates[0].RawSubjectPublicKeyInfo) == 0 {
+			return fmt.Errorf("the peer presented no public key")
+		}
+		return nil
+	}
+
+	var client *tls.Config
+	if clientTLSConfig != nil {
+		client = clientTLSConfig.Clone()
+	} else {
+		client = &tls.Config{InsecureSkipVerify: true}
+	}
+	client.Certificates = serverTLSConfig.Certificates
+	return server, client
+}
+
+// identityCache remembers the identities already checked against the key of their address,
+// so the calls don't hash the key once per identity index every time.
+type identityCache struct {
+	mu       sync.Mutex
+	verified map[string]bool // keyed by the identifier, the key and the identity count
+}
+
+func newIdentityCache() *identityCache {
+	return &identityCache{verified: make(map[string]bool)}
+}
+
+// matches is identityMatchesKey of the node, cached, the identities of a host share the hash and m.
+func (cache *identityCache) matches(node *Node, nodeInfo *NodeInfo, key []byte, count int) bool {
+	cacheKey := nodeInfo.Identifier.String() + "/" + strconv.Itoa(count) + "/" + string(key)
+	cache.mu.Lock()
+	matched, ok := cache.verified[cacheKey]
+	cache.mu.Unlock()
+	if ok {
+		return matched
+	}
+	matched = node.identityMatchesKey(nodeInfo, key, count)
+	cache.mu.Lock()
+	cache.verified[cacheKey] = matched
+	cache.mu.Unlock()
+	return matched
+}
+
+// secureIdentifiers tells if the node derives the identifiers from the keys, and checks those of its peers.
+func (node *Node) secureIdentifiers() bool {
+	return node.idPolicy == IdPolicySecure
+}
+
+// peerCheck returns the check of the peer the calls to the remote node go through, with IdPolicySecure:
+// the identifier of the remote node must be derived from the key the peer presents in the TLS handshake of that connection.
+// Without the policy, or for a node only known by its address, it is nil.
+// The check fails with errIdentityMismatch if the identifier is not the peer's.
+func (remote *RemoteNode) peerCheck(ctx context.Context) PeerCheck {
+	if !remote.local.secureIdentifiers() || remote.info.Empty() {
+		return nil
+	}
+	return func(key []byte) error {
+		if len(key) == 0 {
+			return fmt.Errorf("%s presented no certificate", remote.address())
+		}
+		// the peer presents the key at the address, the primary identity of the key needs no handshake to know it
+		if remote.local.host.identities.matches(remote.local, remote.info, key, 1) {
+			return nil
+		}
+		// a host that can't be asked for its identities (e.g. it refuses the handshake) only gets its primary identity
+		matched, err := remote.local.keyVouchesFor(ctx, remote.info, key)
+		if errors.Is(err, errIdentityMismatch) {
+			return err
+		}
+		if err != nil || !matched {
+			log.Error("Rejected %v: its identifier is not derived from the key presented at %s", remote.info, remote.address())
+			return fmt.Errorf("%v: %w", remote.info, errIdentityMismatch)
+		}
+		return nil
+	}
+}
+
+// keyVouchesFor tells if the identifier of the NodeInfo is one of the identities of the key,
+// among those the host at its address runs. The key must be the one the host presented at that address,
+// in a handshake made by the node, so a key doesn't vouch for its identities at an address it isn't served at.
+func (node *Node) keyVouchesFor(ctx context.Context, nodeInfo *NodeInfo, key []byte) (bool, error) {
+	address := nodeInfo.IpAddress + ":" + nodeInfo.Port
+	session, err := node.outgoingSession(ctx, address)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(session.Key, key) {
+		log.Error("Rejected the key claimed for %v: %s presented another one", nodeInfo, address)
+		return false, fmt.Errorf("%s presented another key: %w", address, errIdentityMismatch)
+	}
+	return node.host.identities.matches(node, nodeInfo, key, session.Peer.VirtualNodes), nil
+}
+
+// outgoingSession returns the session of a handshake made by the node with the host at the address,
+// so the host answered at that address (a HelloRPC may claim any address), and makes the handshake if there is none.
+// The session tells the key the host presented and the number of identities it runs,
+// the identity that answered must be one of the identities of that key.
+func (node *Node) outgoingSession(ctx context.Context, address string) (*Session, error) {
+	session := node.host.sessions.get(address)
+	if session == nil || !session.Outgoing {
+		ipAddress, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		// the peer is only known by its address, so the handshake doesn't verify it, which would recurse
+		session, err = node.Remote(NewNodeInfoWithAddress(ipAddress, port)).Handshake(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("can't ask %s for its identities: %w", address, err)
+		}
+	}
+	if !node.host.identities.matches(node, &session.Peer.Info, session.Key, session.Peer.VirtualNodes) {
+		log.Error("Rejected %s: it answered the handshake as %v, which is not derived from its key", address, session.Peer.Info)
+		return nil, fmt.Errorf("%s answered as %v: %w", address, session.Peer.Info, errIdentityMismatch)
+	}
+	return session, nil
+}
+
+// forgedIdentity tells if the identifier of the entry is not one of the identities of the peer at its address, with IdPolicySecure.
+// The outgoing session with the peer tells its key and identities, without a call each round.
+// Without one, a ping makes the handshake through its peer check, and fails if the identifier is not the peer's,
+// an unreachable peer is not forged, it is left to the failure detector.
+func (node *Node) forgedIdentity(ctx context.Context, nodeInfo *NodeInfo) bool {
+	if !node.secureIdentifiers() {
+		return false
+	}
+	if session := node.host.sessions.get(nodeInfo.IpAddress + ":" + nodeInfo.Port); session != nil && session.Outgoing && session.Key != nil {
+		return !node.host.identities.matches(node, nodeInfo, session.Key, session.Peer.VirtualNodes)
+	}
+	return errors.Is(node.Remote(nodeInfo).PingContext(ctx), errIdentityMismatch)
+}
@@ -0,0 +1,202 @@
+package node
+
+import (
+	"bytes"
+	cfs "chord/cachefilesystem"
+	"chord/tools"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/rpc"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testCertificate creates a self-signed certificate, and returns the server TLS configuration using it and its public key.
+func testCertificate(t *testing.T) (*tls.Config, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate a key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "chord"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create a certificate: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse the certificate: %v", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	return tlsConfig, certificate.RawSubjectPublicKeyInfo
+}
+
+// startSecureNode starts a node with IdPolicySecure and the certificate, which it presents on the network.
+// The caller closes the node, or makes it quit.
+func startSecureNode(t *testing.T, network *MemoryNetwork, port string, joinNode *Node, tlsConfig *tls.Config, key []byte, options ...Option) *Node {
+	dir := t.TempDir()
+	network.SetKey("127.0.0.1:"+port, key)
+	options = append(options, WithTransport(network.Transport("127.0.0.1:"+port)), WithIdPolicy(IdPolicySecure))
+	node, err := NewNode(10, 2, "127.0.0.1", port, cfs.CacheStorageFactory,
+		filepath.Join(dir, "storage"), filepath.Join(dir, "backup"), 50, 20, 50, true, tlsConfig, nil, options...)
+	if err != nil {
+		t.Fatalf("Failed to create node %s: %v", port, err)
+	}
+	if node.info.Identifier.Cmp(node.keyIdentifier(key, 0)) != 0 {
+		t.Fatalf("Node %s has identifier %v, want the hash of its key", port, node.info.Identifier)
+	}
+	if joinNode == nil {
+		node.create()
+	} else if err := node.joinRing(node.ctx, NewNodeInfoWithAddress("127.0.0.1", joinNode.info.Port)); err != nil {
+		t.Fatalf("Node %s failed to join: %v", port, err)
+	}
+	node.startServer()
+	node.startPeriodicTasks()
+	return node
+}
+
+// testIdentities has the hash and m of the test nodes, to compute their identifiers before they exist.
+var testIdentities = &Node{hashName: tools.DefaultHash, identifierLength: 10}
+
+// startSecureRing starts n nodes with IdPolicySecure, on the ports from 4170, and waits for their ring.
+// The keys are random, the ones whose identifier collides in the small test ring are drawn again.
+func startSecureRing(t *testing.T, network *MemoryNetwork, n int) []*Node {
+	var nodes []*Node
+	used := make(map[string]bool)
+	for i := 0; i < n; i++ {
+		var joinNode *Node
+		if i > 0 {
+			joinNode = nodes[0]
+		}
+		tlsConfig, key := testCertificate(t)
+		for used[testIdentities.keyIdentifier(key, 0).String()] {
+			tlsConfig, key = testCertificate(t)
+		}
+		used[testIdentities.keyIdentifier(key, 0).String()] = true
+		node := startSecureNode(t, network, strconv.Itoa(4170+i), joinNode, tlsConfig, key)
+		t.Cleanup(node.Close)
+		nodes = append(nodes, node)
+	}
+	waitForRing(t, nodes, 10*time.Second)
+	return nodes
+}
+
+func TestSecureIdentifiers(t *testing.T) {
+	network := NewMemoryNetwork(1)
+	nodes := startSecureRing(t, network, 3)
+
+	// a node without the policy is refused by the handshake
+	plain := startTestNode(t, network, "4179", nil)
+	if err := plain.joinRing(plain.ctx, NewNodeInfoWithAddress("127.0.0.1", "4170")); !errors.Is(err, errNotCompatible) {
+		t.Fatalf("Join without secure identifiers: got %v, want a %v error", err, errNotCompatible)
+	}
+
+	// it can still claim the identifier just before the victim, as if it were its predecessor
+	_, plainKey := testCertificate(t)
+	network.SetKey("127.0.0.1:4179", plainKey)
+	victim := nodes[0]
+	forged := &NodeInfo{IpAddress: "127.0.0.1", Port: "4179"}
+	for offset := int64(1); ; offset++ {
+		forged.Identifier = new(big.Int).Sub(victim.info.Identifier, big.NewInt(offset))
+		forged.Identifier.Mod(forged.Identifier, big.NewInt(1<<10))
+		if !testIdentities.identityMatchesKey(forged, plainKey, maxVirtualIndex+1) && !sameNode(forged, victim.GetPredecessor()) {
+			break
+		}
+	}
+	if err := victim.Remote(forged).PingContext(victim.ctx); !errors.Is(err, errIdentityMismatch) {
+		t.Fatalf("Ping of a forged identity: got %v, want a %v error", err, errIdentityMismatch)
+	}
+	// the entries of the lists are checked against the session with their host, the genuine ones pass
+	if !victim.forgedIdentity(victim.ctx, forged) || victim.forgedIdentity(victim.ctx, &nodes[1].info) {
+		t.Fatalf("forgedIdentity: %v is not reported, or %v is", forged, nodes[1].info)
+	}
+	if session, err := victim.Remote(&nodes[1].info).Handshake(victim.ctx); err != nil || !bytes.Equal(session.Key, nodes[1].ownKey) {
+		t.Fatalf("The session with %v doesn't keep its key (%v)", nodes[1].info, err)
+	}
+	if plain.forgedIdentity(plain.ctx, forged) {
+		t.Fatalf("forgedIdentity reports %v without secure identifiers", forged)
+	}
+	victim.Notify(forged, nil)
+	if predecessor := victim.GetPredecessor(); sameNode(predecessor, forged) {
+		t.Fatalf("The victim adopted the forged predecessor %v", predecessor)
+	}
+	if victim.forgedIdentity(victim.ctx, &nodes[1].info) {
+		t.Fatalf("The identity of %v is rejected", nodes[1].info)
+	}
+
+	// the key of a host only vouches for the identities it announces, a single node has no key#k identity
+	virtual := &NodeInfo{Identifier: nodes[1].keyIdentifier(nodes[1].ownKey, 5), IpAddress: "127.0.0.1", Port: nodes[1].info.Port}
+	if err := victim.Remote(virtual).PingContext(victim.ctx); !errors.Is(err, errIdentityMismatch) {
+		t.Fatalf("Ping of an identity the host doesn't announce: got %v, want a %v error", err, errIdentityMismatch)
+	}
+	// but a host with two identities vouches for the second one
+	tlsConfig, key := testCertificate(t)
+	for testIdentities.keyIdentifier(key, 0).Cmp(testIdentities.keyIdentifier(key, 1)) == 0 {
+		tlsConfig, key = testCertificate(t)
+	}
+	twoIdentities := startSecureNode(t, network, "4178", nil, tlsConfig, key, WithVirtualNodes(2))
+	t.Cleanup(twoIdentities.Close)
+	second := &NodeInfo{Identifier: testIdentities.keyIdentifier(key, 1), IpAddress: "127.0.0.1", Port: "4178"}
+	if err := victim.Remote(second).PingContext(victim.ctx); err != nil {
+		t.Fatalf("Ping of the second identity of a host: %v", err)
+	}
+}
+
+func TestPeerCheckOnRedial(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	address := probe.Addr().String()
+	_ = probe.Close()
+	server := rpc.NewServer()
+	if err := server.RegisterName(RPCHandlerName, &RPCHandler{}); err != nil {
+		t.Fatalf("Failed to register the handler: %v", err)
+	}
+
+	firstTLSConfig, firstKey := testCertificate(t)
+	first := newTCPTransport(nil, firstTLSConfig)
+	if err := first.Listen(address, server); err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	client := newTCPTransport(func(ctx context.Context, address string) (net.Conn, error) {
+		dialer := &tls.Dialer{Config: &tls.Config{InsecureSkipVerify: true}}
+		return dialer.DialContext(ctx, "tcp", address)
+	}, nil)
+	t.Cleanup(client.Close)
+	check := func(key []byte) error {
+		if !bytes.Equal(key, firstKey) {
+			return errIdentityMismatch
+		}
+		return nil
+	}
+	if err := client.Ping(context.Background(), address, RPCHandlerName, time.Second, check); err != nil {
+		t.Fatalf("Ping of the first peer: %v", err)
+	}
+
+	// another process takes the address, the call reconnects and must check the key of the new connection
+	first.StopListening()
+	secondTLSConfig, _ := testCertificate(t)
+	second := newTCPTransport(nil, secondTLSConfig)
+	if err := second.Listen(address, server); err != nil {
+		t.Fatalf("Failed to listen again: %v", err)
+	}
+	t.Cleanup(second.StopListening)
+	if err := client.Ping(context.Background(), address, RPCHandlerName, time.Second, check); !errors.Is(err, errIdentityMismatch) {
+		t.Fatalf("Ping after the peer changed: got %v, want a %v error", err, errIdentityMismatch)
+	}
+}
@@ -0,0 +1,263 @@
+package node
+
+import (
+	"chord/log"
+	"chord/storage"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"sync"
+	"time"
+)
+
+// maxSignatureAge is how far the time of a signed message may be from the clock of its receiver,
+// it bounds the replays and tolerates the clock skew between the hosts.
+const maxSignatureAge = 2 * time.Minute
+
+// errUnauthenticated marks a membership message whose sender can't be proven to be the node it claims to be.
+var errUnauthenticated = errors.New("membership message not authenticated")
+
+// The membership messages (notify, the leave notifications and the file transfers) change who a node
+// trusts as its neighbors and what it stores. With IdPolicySecure, they are signed with the node key,
+// the key of the TLS certificate the identifiers are derived from: the receiver checks that the identifier
+// of the signer is derived from the key in the signature, and that the key signed the message.
+// So a process can't speak for another node, even one it can reach, and it can't pick an identifier that passes.
+// Without the policy, the identifiers are not bound to any key, and the messages are not signed:
+// the sender remembers them, and the receiver calls the claimed sender back at its address to confirm it sent them.
+// So a process can only speak for the identities served at the addresses it listens on.
+
+// sign signs the message of the method, sent by the node to the receiver, payload is the content of the message,
+// see infoPayload and filesPayload. Without IdPolicySecure, the Signature has no key and no signature,
+// the message is remembered instead, see confirmSender.
+func (node *Node) sign(method string, receiver *NodeInfo, payload []byte) Signature {
+	if !node.secureIdentifiers() {
+		signature := Signature{Signer: node.info, Time: node.clock.Now().UnixNano()}
+		node.host.sent.add(messageDigest(method, receiver, &signature, payload), node.clock.Now())
+		return signature
+	}
+	signature := Signature{Signer: node.info, PublicKey: node.ownKey, Time: node.clock.Now().UnixNano()}
+	digest := messageDigest(method, receiver, &signature, payload)
+	var opts crypto.SignerOpts = crypto.SHA256
+	if _, ok := node.signer.(ed25519.PrivateKey); ok {
+		opts = crypto.Hash(0) // ed25519 signs the digest as the message
+	}
+	sig, err := node.signer.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		log.Error("Failed to sign %s to %v: %v", method, receiver, err)
+		return signature // sent without the signature, the receiver rejects it
+	}
+	signature.Sig = sig
+	return signature
+}
+
+// authenticate checks that a membership message received by the node comes from the signer it claims.
+// With IdPolicySecure, the signature is checked:
+//  1. the identifier of the signer is derived from the key in the signature, which its host presented
+//     at its address, as one of the identities the host announces, see keyVouchesFor.
+//  2. the key signed this method, to this node, with this payload.
+//  3. the message was signed less than maxSignatureAge ago, by the clock of the node.
+//
+// Without the policy, the signer is called back at its address to confirm it sent the message, see confirmSender.
+// It fails with errUnauthenticated, and logs the rejected message.
+func (node *Node) authenticate(method string, signature *Signature, payload []byte) error {
+	var err error
+	if node.secureIdentifiers() {
+		err = node.checkSignature(method, signature, payload)
+	} else {
+		err = node.confirmSender(node.ctx, method, signature, payload)
+	}
+	if err != nil {
+		log.Error("Rejected %s claimed by %v: %v", method, signature.Signer, err)
+		return fmt.Errorf("%s refused by %v: %w: %v", method, node.info, errUnauthenticated, err)
+	}
+	return nil
+}
+
+func (node *Node) checkSignature(method string, signature *Signature, payload []byte) error {
+	if len(signature.Sig) == 0 {
+		return fmt.Errorf("the message is not signed")
+	}
+	if signature.Signer.Empty() {
+		return fmt.Errorf("the signer is empty")
+	}
+	if err := node.checkAge(signature); err != nil {
+		return err
+	}
+	matched, err := node.keyVouchesFor(node.ctx, &signature.Signer, signature.PublicKey)
+	if err != nil {
+		return fmt.Errorf("the host of the signer is not verified: %v", err)
+	}
+	if !matched {
+		return fmt.Errorf("the identifier of the signer is not derived from its key")
+	}
+	key, err := x509.ParsePKIXPublicKey(signature.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid key: %v", err)
+	}
+	digest := messageDigest(method, &node.info, signature, payload)
+	var valid bool
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(key, digest, signature.Sig)
+	case *rsa.PublicKey:
+		valid = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, signature.Sig) == nil
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(key, digest, signature.Sig)
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+	if !valid {
+		return fmt.Errorf("bad signature")
+	}
+	return nil
+}
+
+// checkAge checks that the message was signed less than maxSignatureAge ago, by the clock of the node.
+func (node *Node) checkAge(signature *Signature) error {
+	age := node.clock.Now().Sub(time.Unix(0, signature.Time))
+	if age > maxSignatureAge || age < -maxSignatureAge {
+		return fmt.Errorf("the message was signed %v away from the clock of the node", age.Round(time.Second))
+	}
+	return nil
+}
+
+// confirmSender checks, without IdPolicySecure, that the signer sent the message: the node calls the signer back
+// at its address, the identity that answers must be the signer, and it must remember sending this message to the node.
+// The notify of the nodes without the predecessor list has no time, so it is only checked against
+// the identity that answers at the address.
+func (node *Node) confirmSender(ctx context.Context, method string, signature *Signature, payload []byte) error {
+	if signature.Signer.Empty() {
+		return fmt.Errorf("the sender is empty")
+	}
+	remote := node.Remote(&signature.Signer)
+	if signature.Time == 0 {
+		info, err := remote.GetNodeInfoContext(ctx)
+		if err != nil {
+			return fmt.Errorf("can't call back %s: %v", remote.address(), err)
+		}
+		if !sameNode(info, &signature.Signer) {
+			return fmt.Errorf("%s answers as %v", remote.address(), info)
+		}
+		return nil
+	}
+	if err := node.checkAge(signature); err != nil {
+		return err
+	}
+	reply, err := remote.ConfirmContext(ctx, messageDigest(method, &node.info, signature, payload))
+	if err != nil {
+		return fmt.Errorf("can't call back %s: %v", remote.address(), err)
+	}
+	if !sameNode(&reply.Info, &signature.Signer) {
+		return fmt.Errorf("%s answers as %v", remote.address(), reply.Info)
+	}
+	if !reply.Sent {
+		return fmt.Errorf("%v didn't send the message", signature.Signer)
+	}
+	return nil
+}
+
+// sentMessages remembers the digests of the membership messages the host sent, without IdPolicySecure,
+// until they are too old to be accepted, so the receivers can ask if they come from it, see confirmSender.
+type sentMessages struct {
+	mu   sync.Mutex
+	sent map[string]time.Time // keyed by the digest, see messageDigest
+}
+
+func newSentMessages() *sentMessages {
+	return &sentMessages{sent: make(map[string]time.Time)}
+}
+
+// add remembers the message sent at now, and forgets the messages older than maxSignatureAge.
+func (messages *sentMessages) add(digest []byte, now time.Time) {
+	messages.mu.Lock()
+	defer messages.mu.Unlock()
+	for key, sentAt := range messages.sent {
+		if now.Sub(sentAt) > maxSignatureAge {
+			delete(messages.sent, key)
+		}
+	}
+	messages.sent[string(digest)] = now
+}
+
+// contains checks if the host sent the message.
+func (messages *sentMessages) contains(digest []byte) bool {
+	messages.mu.Lock()
+	defer messages.mu.Unlock()
+	_, ok := messages.sent[string(digest)]
+	return ok
+}
+
+// sameHostAs tells if the signer lives on the host of the neighbor, the leaving identities of a host
+// may hand over a neighbor that one of the others knows.
+func sameHostAs(signer *NodeInfo, neighbor *NodeInfo) bool {
+	return !neighbor.Empty() && signer.IpAddress == neighbor.IpAddress && signer.Port == neighbor.Port
+}
+
+// messageDigest is the SHA-256 of the method, the receiver, the signer, its key, the time and the payload,
+// each one prefixed by its length, so two different messages can't have the same digest.
+func messageDigest(method string, receiver *NodeInfo, signature *Signature, payload []byte) []byte {
+	digest := sha256.New()
+	writeField(digest, []byte(method))
+	writeField(digest, []byte(nodeKey(receiver)))
+	writeField(digest, []byte(nodeKey(&signature.Signer)))
+	writeField(digest, signature.PublicKey)
+	writeField(digest, binary.BigEndian.AppendUint64(nil, uint64(signature.Time)))
+	writeField(digest, payload)
+	return digest.Sum(nil)
+}
+
+func writeField(digest hash.Hash, field []byte) {
+	digest.Write(binary.BigEndian.AppendUint64(nil, uint64(len(field))))
+	digest.Write(field)
+}
+
+// infoPayload is the payload of a message made of NodeInfos.
+func infoPayload(nodeInfos ...*NodeInfo) []byte {
+	payload := sha256.New()
+	for _, nodeInfo := range nodeInfos {
+		writeField(payload, []byte(nodeKey(nodeInfo)))
+	}
+	return payload.Sum(nil)
+}
+
+// filesPayload is the payload of a message carrying files.
+func filesPayload(files storage.FileList) []byte {
+	payload := sha256.New()
+	for _, file := range files {
+		writeField(payload, []byte(file.Key))
+		writeField(payload, file.Value)
+	}
+	return payload.Sum(nil)
+}
+
+/*                             RPC Part                             */
+
+// Confirm A wrap of ConfirmRPC method, ask the remote node if it sent the membership message with the digest.
+func (remote *RemoteNode) Confirm(digest []byte) (*ConfirmReply, error) {
+	return remote.ConfirmContext(context.Background(), digest)
+}
+
+// ConfirmContext is Confirm with a context, the call is abandoned when ctx is done.
+func (remote *RemoteNode) ConfirmContext(ctx context.Context, digest []byte) (*ConfirmReply, error) {
+	reply := &ConfirmReply{}
+	err := remote.callRPC(ctx, "ConfirmRPC", &ConfirmArgs{Digest: digest}, reply)
+	return reply, err
+}
+
+// ConfirmRPC : tell if the node sent the membership message, and which identity answers, see confirmSender
+func (handler *RPCHandler) ConfirmRPC(args *ConfirmArgs, reply *ConfirmReply) error {
+	reply.Sent = handler.node.host.sent.contains(args.Digest)
+	reply.Info = handler.node.info
+	return nil
+}
+
+/*                             RPC Part                             */
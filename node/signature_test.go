@@ -0,0 +1,168 @@
+package node
+
+import (
+	"chord/storage"
+	"crypto"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestSignedMembership(t *testing.T) {
+	network := NewMemoryNetwork(1)
+	nodes := startSecureRing(t, network, 3)
+	victim := nodes[0]
+	handler := &RPCHandler{node: victim}
+
+	// an attacker with a key of its own, outside the ring
+	tlsConfig, key := testCertificate(t)
+	attacker := startSecureNode(t, network, "4179", nil, tlsConfig, key)
+	t.Cleanup(attacker.Close)
+	predecessor := victim.GetPredecessor()
+
+	// it notifies the victim in the name of another identifier, the one right before the victim
+	forged := NodeInfo{IpAddress: "127.0.0.1", Port: "4179"}
+	for offset := int64(1); ; offset++ {
+		forged.Identifier = new(big.Int).Sub(victim.info.Identifier, big.NewInt(offset))
+		forged.Identifier.Mod(forged.Identifier, big.NewInt(1<<10))
+		if !testIdentities.identityMatchesKey(&forged, key, maxVirtualIndex+1) {
+			break
+		}
+	}
+	payload := notifyPayload(&forged, nil)
+	args := &NotifyArgs{Predecessor: forged, Signature: attacker.sign("NotifyListRPC", &victim.info, payload)}
+	if err := handler.NotifyListRPC(args, &Empty{}); !errors.Is(err, errUnauthenticated) {
+		t.Fatalf("Notify signed by another node: got %v, want a %v error", err, errUnauthenticated)
+	}
+	// or claims to be the signer too, but the identifier doesn't come from its key
+	args.Signature.Signer = forged
+	if err := handler.NotifyListRPC(args, &Empty{}); !errors.Is(err, errUnauthenticated) {
+		t.Fatalf("Notify signed with a forged identity: got %v, want a %v error", err, errUnauthenticated)
+	}
+	// or sends no signature at all
+	args.Signature = Signature{}
+	if err := handler.NotifyListRPC(args, &Empty{}); !errors.Is(err, errUnauthenticated) {
+		t.Fatalf("Unsigned notify: got %v, want a %v error", err, errUnauthenticated)
+	}
+	if err := handler.NotifyRPC(&forged, &Empty{}); !errors.Is(err, errUnauthenticated) {
+		t.Fatalf("Notify without the predecessor list: got %v, want a %v error", err, errUnauthenticated)
+	}
+	// or signs as its own identity, moved to the address of the predecessor, which presents another key
+	moved := attacker.info
+	moved.IpAddress, moved.Port = predecessor.IpAddress, predecessor.Port
+	args = &NotifyArgs{Predecessor: moved, Signature: signAs(t, attacker, moved, "NotifyListRPC", &victim.info, notifyPayload(&moved, nil))}
+	if err := handler.NotifyListRPC(args, &Empty{}); !errors.Is(err, errUnauthenticated) {
+		t.Fatalf("Notify signed at the address of another host: got %v, want a %v error", err, errUnauthenticated)
+	}
+
+	// a signed leave notification is only taken from the host of the neighbor that leaves
+	leave := attacker.Remote(&victim.info).leaveArgs("NotifyPredecessorLeaveRPC", &attacker.info)
+	if err := handler.NotifyPredecessorLeaveRPC(leave, &Empty{}); !errors.Is(err, errUnauthenticated) {
+		t.Fatalf("Leave notification from a stranger: got %v, want a %v error", err, errUnauthenticated)
+	}
+	if got := victim.GetPredecessor(); !sameNode(got, predecessor) {
+		t.Fatalf("The predecessor of the victim changed from %v to %v", predecessor, got)
+	}
+
+	// a tampered file transfer is refused, and nothing is stored
+	files := storage.FileList{{Key: "stolen", Value: []byte("a")}}
+	store := &StoreFileListArgs{FileList: files, Signature: attacker.sign("StoreFilesRPC", &victim.info, filesPayload(files))}
+	files[0].Value = []byte("b")
+	if err := handler.StoreFilesRPC(store, &StoreFileListReply{}); !errors.Is(err, errUnauthenticated) {
+		t.Fatalf("Tampered file transfer: got %v, want a %v error", err, errUnauthenticated)
+	}
+	if _, err := victim.GetFile("stolen"); err == nil {
+		t.Fatalf("The victim stored the tampered file")
+	}
+	// a validly signed transfer is refused too, the attacker is not a neighbor of the victim
+	store.Signature = attacker.sign("StoreFilesRPC", &victim.info, filesPayload(files))
+	if err := handler.StoreFilesRPC(store, &StoreFileListReply{}); !errors.Is(err, errUnauthenticated) {
+		t.Fatalf("File transfer from a stranger: got %v, want a %v error", err, errUnauthenticated)
+	}
+	if _, err := victim.GetFile("stolen"); err == nil {
+		t.Fatalf("The victim stored the files of a stranger")
+	}
+
+	// the members of the ring sign their own messages, a graceful leave with files goes through
+	tlsConfig, key = testCertificate(t)
+	for _, node := range nodes {
+		for node.info.Identifier.Cmp(node.keyIdentifier(key, 0)) == 0 {
+			tlsConfig, key = testCertificate(t) // the identifier is taken in the small test ring
+		}
+	}
+	leaving := startSecureNode(t, network, "4173", victim, tlsConfig, key)
+	waitForRing(t, append(nodes, leaving), 10*time.Second)
+	if err := leaving.StoreFiles(storage.FileList{{Key: "kept", Value: []byte("a")}}); err != nil {
+		t.Fatalf("Failed to store a file: %v", err)
+	}
+	report, err := leaving.Quit(false, nil)
+	if err != nil || !report.Complete() {
+		t.Fatalf("Graceful leave in a signed ring: report %+v, error %v", report, err)
+	}
+}
+
+// signAs signs the message with the key of the node, in the name of another identity.
+func signAs(t *testing.T, node *Node, signer NodeInfo, method string, receiver *NodeInfo, payload []byte) Signature {
+	signature := Signature{Signer: signer, PublicKey: node.ownKey, Time: node.clock.Now().UnixNano()}
+	sig, err := node.signer.Sign(rand.Reader, messageDigest(method, receiver, &signature, payload), crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Failed to sign %s: %v", method, err)
+	}
+	signature.Sig = sig
+	return signature
+}
+
+func TestConfirmedMembership(t *testing.T) {
+	network := NewMemoryNetwork(1)
+	nodes := []*Node{startTestNode(t, network, "4170", nil)}
+	for _, port := range []string{"4171", "4172"} {
+		nodes = append(nodes, startTestNode(t, network, port, nodes[0]))
+	}
+	waitForRing(t, nodes, 10*time.Second)
+	victim := nodes[0]
+	handler := &RPCHandler{node: victim}
+	predecessor := victim.GetPredecessor()
+
+	// without secure identifiers, an attacker notifies the victim in the name of its predecessor
+	attacker := startTestNode(t, network, "4179", nil)
+	payload := notifyPayload(predecessor, nil)
+	args := &NotifyArgs{Predecessor: *predecessor, Signature: attacker.sign("NotifyListRPC", &victim.info, payload)}
+	if err := handler.NotifyListRPC(args, &Empty{}); !errors.Is(err, errUnauthenticated) {
+		t.Fatalf("Notify sent by another node: got %v, want a %v error", err, errUnauthenticated)
+	}
+	// the predecessor is called back, it didn't send the message
+	args.Signature.Signer = *predecessor
+	if err := handler.NotifyListRPC(args, &Empty{}); !errors.Is(err, errUnauthenticated) {
+		t.Fatalf("Notify in the name of the predecessor: got %v, want a %v error", err, errUnauthenticated)
+	}
+	// an identity the attacker doesn't serve can't answer the call back
+	forged := attacker.info
+	forged.Identifier = new(big.Int).Sub(victim.info.Identifier, big.NewInt(1))
+	args = &NotifyArgs{Predecessor: forged, Signature: attacker.sign("NotifyListRPC", &victim.info, notifyPayload(&forged, nil))}
+	args.Signature.Signer = forged
+	if err := handler.NotifyListRPC(args, &Empty{}); !errors.Is(err, errUnauthenticated) {
+		t.Fatalf("Notify of an identity not served: got %v, want a %v error", err, errUnauthenticated)
+	}
+	if err := handler.NotifyRPC(&forged, &Empty{}); !errors.Is(err, errUnauthenticated) {
+		t.Fatalf("Notify without the predecessor list: got %v, want a %v error", err, errUnauthenticated)
+	}
+	leave := attacker.Remote(&victim.info).leaveArgs("NotifyPredecessorLeaveRPC", &attacker.info)
+	leave.Signature.Signer = *predecessor
+	if err := handler.NotifyPredecessorLeaveRPC(leave, &Empty{}); !errors.Is(err, errUnauthenticated) {
+		t.Fatalf("Leave notification in the name of the predecessor: got %v, want a %v error", err, errUnauthenticated)
+	}
+	if got := victim.GetPredecessor(); !sameNode(got, predecessor) {
+		t.Fatalf("The predecessor of the victim changed from %v to %v", predecessor, got)
+	}
+
+	// the members of the ring confirm their own messages
+	args = &NotifyArgs{Predecessor: *predecessor, Signature: nodes[1].sign("NotifyListRPC", &victim.info, payload)}
+	if !sameNode(&nodes[1].info, predecessor) {
+		args.Signature = nodes[2].sign("NotifyListRPC", &victim.info, payload)
+	}
+	if err := handler.NotifyListRPC(args, &Empty{}); err != nil {
+		t.Fatalf("Notify of the predecessor: %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+package node
+
+import (
+	"chord/log"
+	"chord/tools"
+	"context"
+	"math"
+	"math/big"
+	"time"
+)
+
+// minLookupSteps is the least number of steps a lookup may take, it keeps the small rings
+// (or a node that doesn't know its neighbors yet) as lenient as before the size estimate.
+const minLookupSteps = 10
+
+// ringWalkTimeout bounds the walk of the ring a peer asks for, see GetRingSizeRPC.
+const ringWalkTimeout = 30 * time.Second
+
+// ringCount is an exact size of the ring, and the time it was walked.
+type ringCount struct {
+	size int
+	at   time.Time
+}
+
+// EstimateSize estimates the number of nodes in the ring from the density of the successor list:
+// the k successors cover the distance d from the node, so the ring has about k * 2^m / d nodes.
+// The predecessor list adds its own entries to the sample, on the other side of the node.
+// When a list wraps around the ring, the ring is smaller than the lists and the count is exact.
+// It costs no RPC, but with virtual nodes the list skips the identities of the same host, so it is rougher.
+func (node *Node) EstimateSize() int {
+	self := node.info.Identifier
+	seen := map[string]bool{self.String(): true}
+
+	// the k successors cover (n, s_k], the predecessors cover [p_j, n), the spans add up
+	gaps := 0
+	span := new(big.Int)
+	successorSpan := new(big.Int)
+	for _, successor := range node.GetSuccessors() {
+		if successor.Empty() {
+			break
+		}
+		if seen[successor.Identifier.String()] {
+			return len(seen) // the list wrapped around, we know every node
+		}
+		seen[successor.Identifier.String()] = true
+		gaps++
+		successorSpan = node.distance(self, successor.Identifier)
+	}
+	if gaps == 0 {
+		return 1 // alone, or not joined yet
+	}
+	span.Add(span, successorSpan)
+
+	predecessorSpan := new(big.Int)
+	for _, predecessor := range node.GetPredecessors() {
+		if predecessor.Empty() {
+			break
+		}
+		if seen[predecessor.Identifier.String()] {
+			return len(seen) // the lists met behind the node, we know every node
+		}
+		seen[predecessor.Identifier.String()] = true
+		gaps++
+		predecessorSpan = node.distance(predecessor.Identifier, self)
+	}
+	span.Add(span, predecessorSpan)
+
+	// size = gaps * 2^m / span
+	size := new(big.Float).SetInt(tools.TwoM(node.identifierLength))
+	size.Mul(size, big.NewFloat(float64(gaps)))
+	size.Quo(size, new(big.Float).SetInt(span))
+	estimate, _ := size.Float64()
+	return max(int(math.Round(estimate)), len(seen))
+}
+
+// CountRing counts the nodes of the ring exactly, by walking it from the node.
+// It costs one RPC per node, see walkRing, so it is only done on demand (the RINGSIZE command,
+// or a peer asking GetRingSizeRPC for it), with a deadline in ctx.
+// The count is kept, PrintState shows it next to the estimate.
+func (node *Node) CountRing(ctx context.Context) (int, error) {
+	defer log.LogFunction()()
+
+	members, err := node.walkRing(ctx, &node.info)
+	if err != nil {
+		return 0, err
+	}
+	node.host.lastCount.Store(&ringCount{size: len(members), at: node.clock.Now()})
+	return len(members), nil
+}
+
+// maxLookupSteps is the number of steps a lookup may take: a lookup takes about log2 N hops,
+// twice as many leave room for stale fingers and for an estimate on the low side.
+func (node *Node) maxLookupSteps() int {
+	size := node.EstimateSize()
+	return max(2*int(math.Ceil(math.Log2(float64(size)))), minLookupSteps)
+}
+
+/*                             RPC Part                             */
+
+// GetRingSize A wrap of GetRingSizeRPC method, it returns the estimated size of the ring seen by the remote node,
+// and the exact size if exact is true, which makes the remote node walk the ring, at most ringWalkTimeout.
+func (remote *RemoteNode) GetRingSize(exact bool) (*RingSizeReply, error) {
+	return remote.GetRingSizeContext(context.Background(), exact)
+}
+
+// GetRingSizeContext is GetRingSize with a context, the call is abandoned when ctx is done.
+// The exact walk takes longer than a call, it gets ringWalkTimeout.
+func (remote *RemoteNode) GetRingSizeContext(ctx context.Context, exact bool) (*RingSizeReply, error) {
+	reply := &RingSizeReply{}
+	if !exact {
+		err := remote.callRPC(ctx, "GetRingSizeRPC", &RingSizeArgs{}, reply)
+		return reply, err
+	}
+	err := remote.callRPCWithTimeout(ctx, "GetRingSizeRPC", &RingSizeArgs{Exact: true}, reply, ringWalkTimeout+remote.local.callTimeout)
+	return reply, err
+}
+
+// GetRingSizeRPC : estimate the size of the ring, it costs no RPC, and count it if asked, bounded by ringWalkTimeout
+func (handler *RPCHandler) GetRingSizeRPC(args *RingSizeArgs, reply *RingSizeReply) error {
+	reply.Estimate = handler.node.EstimateSize()
+	if !args.Exact {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(handler.node.ctx, ringWalkTimeout)
+	defer cancel()
+	exact, err := handler.node.CountRing(ctx)
+	if err != nil {
+		return err
+	}
+	reply.Exact = exact
+	return nil
+}
@@ -3,67 +3,127 @@ package node
 import (
 	"chord/log"
 	"chord/tools"
+	"context"
+	"errors"
+	"fmt"
 )
 
-var next = 0
-
 // Periodic Background task - stabilize.
-func (node *Node) stabilize() {
+func (node *Node) stabilize(ctx context.Context) {
 	defer log.LogFunction()()
 
+	// an isolated node tries to rejoin instead
+	if node.GetState() != StateJoined {
+		if err := node.rejoin(ctx); err != nil {
+			log.Info("Rejoin failed: %v", err)
+			return
+		}
+	}
+
 	// update the successor list and backup files
-	_ = node.updateReplica()
+	_ = node.updateReplica(ctx)
 
 	// successor.notify(n)
 	successor := node.GetFirstSuccessor()
 	log.Info("Execute %v.notify(%v)", successor, node.info)
-	if err := successor.Notify(&node.info); err != nil {
+	if err := node.Remote(successor).NotifyContext(ctx, &node.info, node.GetPredecessors()); err != nil {
 		log.Error("Failed to notify the successor %v", successor)
 		return
 	}
 }
 
 // Periodic Background task - fixFingers.
-func (node *Node) fixFingers() {
+// It refreshes fingersPerTick entries, the cursor nextFinger remembers where the last run stopped.
+func (node *Node) fixFingers(ctx context.Context) {
 	defer log.LogFunction()()
 
-	next++
-	if next > node.identifierLength-1 {
-		next = 0
+	for k := 0; k < min(node.fingersPerTick, node.identifierLength); k++ {
+		node.nextFinger++
+		if node.nextFinger > node.identifierLength-1 {
+			node.nextFinger = 0
+		}
+		node.fixFinger(ctx, node.nextFinger)
 	}
-	// next \in [0, IdentifierLength-1]
-	// the meaning of next is like i, not the real next
-	// finger[next] = find_successor(n + 2^next)
+}
+
+// fixFinger refreshes the finger i.
+func (node *Node) fixFinger(ctx context.Context, i int) {
+	// i \in [0, IdentifierLength-1]
+	// finger[i] = find_successor(n + 2^i)
 	// finger[0] = find_successor(n + 2^0)
 	// finger[1] = find_successor(n + 2^1)
 	// ...
 	// finger[IdentifierLength-1] = find_successor(n + 2^(IdentifierLength-1))
-	nextIdentifier := node.fingerIndex[next]
-	log.Info("Execute %v.find_successor(%v) for finger[%d]", node.info, nextIdentifier, next)
+	nextIdentifier := node.fingerIndex[i]
+	log.Info("Execute %v.find_successor(%v) for finger[%d]", node.info, nextIdentifier, i)
 
-	tempResult, err := node.info.FindSuccessorIter(nextIdentifier)
+	tempResult, err := node.Remote(&node.info).Lookup(ctx, nextIdentifier)
 	if err != nil {
 		log.Error("%v.find_successor(%v) failed, error: %v", node.info, nextIdentifier, err)
-		node.SetFingerEntry(next, NewNodeInfo())
+		node.SetFingerEntry(i, NewNodeInfo())
 		return
 	}
-	if err := tempResult.LiveCheck(); err != nil {
+	if err := node.Remote(tempResult).LiveCheckContext(ctx); err != nil {
 		log.Error("The result of %v.find_successor(%v): %v", node.info, nextIdentifier, err)
-		node.SetFingerEntry(next, NewNodeInfo())
+		node.SetFingerEntry(i, NewNodeInfo())
 		return
 	}
 	log.Info("The result of %v.find_successor(%v) is %v", node.info, nextIdentifier, tempResult)
-	node.SetFingerEntry(next, tempResult)
+	// any node of the finger's interval will do, take the closest one on the network
+	finger := node.proximityFinger(ctx, i, tempResult)
+	node.SetFingerEntry(i, finger)
+	node.rememberPeer(finger)
+}
+
+// initFingerTable fills the whole finger table when the node joins, as in the Chord paper:
+// if n + 2^i falls before finger[i-1], the two fingers are the same node and no RPC is needed,
+// otherwise the join node looks it up. The fixFingers task refines the entries afterwards.
+// A failed lookup leaves the entry empty, fixFingers fills it later.
+func (node *Node) initFingerTable(ctx context.Context, joinNode *NodeInfo, successor *NodeInfo) {
+	defer log.LogFunction()()
+
+	node.SetFingerEntry(0, successor)
+	lookups := 0
+	for i := 1; i < node.identifierLength; i++ {
+		previous := node.GetFingerEntry(i - 1)
+		if !previous.Empty() && tools.ModIntervalCheck(node.fingerIndex[i], node.info.Identifier, previous.Identifier, false, true) {
+			node.SetFingerEntry(i, previous)
+			continue
+		}
+		lookups++
+		finger, err := node.Remote(joinNode).Lookup(ctx, node.fingerIndex[i])
+		if err != nil {
+			log.Error("%v.find_successor(%v) failed, error: %v", joinNode, node.fingerIndex[i], err)
+			continue
+		}
+		node.SetFingerEntry(i, finger)
+		node.rememberPeer(finger)
+	}
+	log.Info("The finger table of %v is initialized with %d lookups", node.info, lookups)
 }
 
 // Periodic Background task - checkPredecessor.
-func (node *Node) checkPredecessor() {
+// When the predecessor is dead, the next live node of the predecessor list takes its place at once,
+// instead of waiting for it to notify the node.
+func (node *Node) checkPredecessor(ctx context.Context) {
 	defer log.LogFunction()()
 
-	oldPredecessor := node.GetPredecessor()
+	predecessors := node.GetPredecessors()
 
-	if err := oldPredecessor.LiveCheck(); err != nil {
+	if err := node.Remote(predecessors[0]).LiveCheckContext(ctx); err != nil {
 		log.Info("Predecessor: %v", err)
+		for _, candidate := range predecessors[1:] {
+			if candidate.Empty() || candidate.Identifier.Cmp(node.info.Identifier) == 0 {
+				continue
+			}
+			if err := node.Remote(candidate).LiveCheckContext(ctx); err != nil {
+				log.Info("Predecessor list: %v", err)
+				continue
+			}
+			log.Info("Recover the predecessor %v from the predecessor list", candidate)
+			node.SetPredecessor(candidate)
+			return
+		}
 		node.SetPredecessor(NewNodeInfo())
 		return
 	}
@@ -81,7 +141,7 @@ func (node *Node) NotifyLog(nodeInfo *NodeInfo) {
 	// first we need to check the nodeInfo
 	// but actually, we shoulde check it just before we set the predecessor
 	// so we don't need to check if we don't need to set the predecessor, which is the normal case
-	if err := nodeInfo.LiveCheck(); err != nil {
+	if err := node.Remote(nodeInfo).LiveCheckContext(node.ctx); err != nil {
 		log.Error("n' (nodeInfo): %v, do nothing", err)
 		return
 	}
@@ -102,29 +162,60 @@ func (node *Node) NotifyLog(nodeInfo *NodeInfo) {
 	}
 
 	// now the predecessor is set, the node should check its files, try to find the files that should be transferred to the new predecessor
-	node.transferFilesToPredecessor(oldPredecessor)
+	node.transferFilesToPredecessor(node.ctx, oldPredecessor)
 }
 
 // Notify : node n is notified by n' (nodeInfo) to check if n' should be its predecessor
-func (node *Node) Notify(nodeInfo *NodeInfo) {
+// predecessors is the predecessor list of n', it rebuilds the predecessor list of n when n' is its predecessor.
+// It runs on the RPC handler side, so its calls are bounded by the node's own context.
+func (node *Node) Notify(nodeInfo *NodeInfo, predecessors NodeInfoList) {
 	oldPredecessor := node.GetPredecessor()
 	// if oldPredecessor is nil or n' in (oldPredecessor, n)
 	if oldPredecessor.Empty() || tools.ModIntervalCheck(nodeInfo.Identifier, oldPredecessor.Identifier, node.info.Identifier, false, false) {
-		// before setting we need to check the nodeInfo
-		if err := nodeInfo.LiveCheck(); err != nil {
+		// before setting we need to check the nodeInfo, with IdPolicySecure the check fails for a forged identifier
+		if err := node.Remote(nodeInfo).LiveCheckContext(node.ctx); err != nil {
+			if errors.Is(err, errIdentityMismatch) {
+				log.Error("Rejected the notify of %v: %v", nodeInfo, err)
+			}
 			return
 		}
 		node.SetPredecessor(nodeInfo)
+		node.reconcilePredecessors(nodeInfo, predecessors)
 		// now the predecessor is set, the node should check its files, try to find the files that should be transferred to the new predecessor
-		node.transferFilesToPredecessor(oldPredecessor)
+		node.transferFilesToPredecessor(node.ctx, oldPredecessor)
+		return
 	}
 	// in this case, the predecessor is not changed, so we don't need to transfer files
+	if sameNode(nodeInfo, oldPredecessor) {
+		node.reconcilePredecessors(nodeInfo, predecessors)
+	}
+}
+
+// reconcilePredecessors rebuilds the predecessor list from the predecessor and its own predecessor list,
+// symmetric to the successor list. The list stops where it wraps around to the node, in a small ring.
+// With IdPolicySecure, the entries with a forged identifier are left out.
+func (node *Node) reconcilePredecessors(predecessor *NodeInfo, theirs NodeInfoList) {
+	predecessors := NodeInfoList{predecessor}
+	for _, entry := range theirs {
+		if len(predecessors) >= node.predecessorsLength || entry.Empty() ||
+			entry.Identifier.Cmp(node.info.Identifier) == 0 || entry.Identifier.Cmp(predecessor.Identifier) == 0 {
+			break
+		}
+		if node.forgedIdentity(node.ctx, entry) {
+			continue
+		}
+		predecessors = append(predecessors, entry)
+	}
+	for len(predecessors) < node.predecessorsLength {
+		predecessors = append(predecessors, NewNodeInfo())
+	}
+	node.SetPredecessors(predecessors)
 }
 
 // Helper function for Notify
 // Transfer the chosen files.
 // Only invoked by the Notify function.
-func (node *Node) transferFilesToPredecessor(oldPredecessor *NodeInfo) {
+func (node *Node) transferFilesToPredecessor(ctx context.Context, oldPredecessor *NodeInfo) {
 	defer log.LogFunction()()
 
 	predecessor := node.GetPredecessor()
@@ -134,7 +225,12 @@ func (node *Node) transferFilesToPredecessor(oldPredecessor *NodeInfo) {
 		return
 	}
 
-	if oldPredecessor.Empty() || oldPredecessor.LiveCheck() != nil {
+	// the files of the predecessor are in (its own predecessor, predecessor], its own predecessor comes from the predecessor list
+	// without it, the old predecessor is the lower bound, if it is still alive
+	lowerBound := node.GetPredecessors()[1:]
+	if len(lowerBound) > 0 && !lowerBound[0].Empty() {
+		oldPredecessor = lowerBound[0]
+	} else if oldPredecessor.Empty() || node.Remote(oldPredecessor).LiveCheckContext(ctx) != nil {
 		// if the oldPredecessor is nil or not alive, then do nothing
 		log.Info("The oldPredecessor is nil, do nothing")
 		return
@@ -142,8 +238,8 @@ func (node *Node) transferFilesToPredecessor(oldPredecessor *NodeInfo) {
 
 	// first extract the chosen files
 	extractFileList, err := node.ExtractFilesByFilter(func(filename string) bool {
-		// if oldPredecessor is not nil, we select filename ID with (oldPredecessor, predecessor]
-		return tools.ModIntervalCheck(tools.GenerateIdentifier(filename), oldPredecessor.Identifier, predecessor.Identifier, false, true)
+		// we select filename ID with (lower bound, predecessor]
+		return tools.ModIntervalCheck(node.GenerateIdentifier(filename), oldPredecessor.Identifier, predecessor.Identifier, false, true)
 	})
 	if err != nil {
 		log.Error("Failed to extract files: %v", err)
@@ -153,7 +249,7 @@ func (node *Node) transferFilesToPredecessor(oldPredecessor *NodeInfo) {
 	}
 
 	// finally, we send the file list to the predecessor
-	reply, err := predecessor.StoreFiles(extractFileList)
+	reply, err := node.Remote(predecessor).StoreFilesContext(ctx, extractFileList)
 	if err != nil || !reply.Success {
 		log.Error("Failed to StoreFileList: %v", err)
 		// for this error, we need to store these files back to the node's storage system again
@@ -169,16 +265,58 @@ func (node *Node) transferFilesToPredecessor(oldPredecessor *NodeInfo) {
 /*                             RPC Part                             */
 
 // Notify A wrap of NotifyRPC method
-// Notify the node to check if it should be its predecessor
-func (nodeInfo *NodeInfo) Notify(predecessor *NodeInfo) error {
-	return nodeInfo.callRPC("NotifyRPC", predecessor, &Empty{})
+// Notify the node to check if it should be its predecessor, predecessors is the predecessor list of the predecessor.
+func (remote *RemoteNode) Notify(predecessor *NodeInfo, predecessors NodeInfoList) error {
+	return remote.NotifyContext(context.Background(), predecessor, predecessors)
+}
+
+// NotifyContext is Notify with a context, the call is abandoned when ctx is done.
+// The predecessor list is only sent to the nodes that take it, see FeaturePredecessorList,
+// the others are notified with the predecessor alone.
+func (remote *RemoteNode) NotifyContext(ctx context.Context, predecessor *NodeInfo, predecessors NodeInfoList) error {
+	if !remote.supports(ctx, FeaturePredecessorList) {
+		return remote.callRPC(ctx, "NotifyRPC", predecessor, &Empty{})
+	}
+	args := &NotifyArgs{
+		Predecessor:  *predecessor,
+		Predecessors: predecessors,
+		Signature:    remote.local.sign("NotifyListRPC", remote.info, notifyPayload(predecessor, predecessors)),
+	}
+	return remote.callRPC(ctx, "NotifyListRPC", args, &Empty{})
+}
+
+// notifyPayload is the signed payload of NotifyListRPC.
+func notifyPayload(predecessor *NodeInfo, predecessors NodeInfoList) []byte {
+	return infoPayload(append(NodeInfoList{predecessor}, predecessors...)...)
 }
 
 // NotifyRPC node n is notified by n' (nodeInfo) to check if n' should be its predecessor
-func (handler *RPCHandler) NotifyRPC(nodeInfo *NodeInfo, reply *Empty) error {
+// It is the notify of the nodes without the predecessor list, which can't sign it either:
+// with IdPolicySecure, it is refused, without it, n' must answer at its address, see confirmSender.
+func (handler *RPCHandler) NotifyRPC(predecessor *NodeInfo, reply *Empty) error {
+	defer log.LogFunction()()
+	if err := handler.node.authenticate("NotifyRPC", &Signature{Signer: *predecessor}, notifyPayload(predecessor, nil)); err != nil {
+		return err
+	}
+	handler.node.asyncHandleRPC(func() {
+		handler.node.Notify(predecessor, nil)
+	})
+	return nil
+}
+
+// NotifyListRPC is NotifyRPC with the predecessor list of n', so n can rebuild its own.
+// Only n' itself can notify, see authenticate.
+func (handler *RPCHandler) NotifyListRPC(args *NotifyArgs, reply *Empty) error {
 	defer log.LogFunction()()
-	asyncHandleRPC(func() {
-		localNode.Notify(nodeInfo)
+	if err := handler.node.authenticate("NotifyListRPC", &args.Signature, notifyPayload(&args.Predecessor, args.Predecessors)); err != nil {
+		return err
+	}
+	if !sameNode(&args.Signature.Signer, &args.Predecessor) {
+		log.Error("Rejected NotifyListRPC: %v claims to be %v", args.Signature.Signer, args.Predecessor)
+		return fmt.Errorf("NotifyListRPC refused by %v: %w: %v is not %v", handler.node.info, errUnauthenticated, args.Signature.Signer, args.Predecessor)
+	}
+	handler.node.asyncHandleRPC(func() {
+		handler.node.Notify(&args.Predecessor, args.Predecessors)
 	})
 	return nil
 }
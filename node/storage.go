@@ -68,10 +68,16 @@ func (node *Node) GetBackupFilesName(index int) []string {
 }
 
 // GetAllBackupFiles gets all backup files from the node.
+// The files of a successor on the same host are not backed up here, they are read from that identity,
+// so the predecessors on other hosts still get them, see updateBackupFiles.
 func (node *Node) GetAllBackupFiles() ([]storage.FileList, error) {
 	fileLists := make([]storage.FileList, node.successorsLength)
 	for i := 0; i < node.successorsLength; i++ {
-		fileList, err := node.backupStorages[i].GetAllFiles()
+		storage := node.backupStorages[i]
+		if identity := node.sameHostIdentity(node.GetSuccessor(i)); identity != nil {
+			storage = identity.localStorage
+		}
+		fileList, err := storage.GetAllFiles()
 		if err != nil {
 			return nil, err
 		}
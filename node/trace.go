@@ -0,0 +1,63 @@
+package node
+
+import (
+	"math/big"
+	"time"
+)
+
+// RouteSource tells where a node finds the answer of find_successor.
+type RouteSource string
+
+const (
+	RouteSuccessor     RouteSource = "successor"      // the identifier is in (n, successor], the answer is found
+	RouteFinger        RouteSource = "finger"         // the closest preceding node comes from the finger table
+	RouteSuccessorList RouteSource = "successor list" // the closest preceding node comes from the successor list of the finger
+	RouteSelf          RouteSource = "self"           // no closer node is known, the node returns itself
+
+	RoutePredecessorList RouteSource = "predecessor list" // the identifier is behind the node, its owner is found in the predecessor list
+)
+
+// TraceHop is one step of a traced lookup.
+type TraceHop struct {
+	Step   int
+	Node   NodeInfo      // the node asked in this step
+	Found  bool          // whether the successor is found in this step
+	Next   NodeInfo      // the answer of the node, the successor if found, or the next hop
+	Source RouteSource   // where the answer comes from
+	RTT    time.Duration // round trip time of the FindSuccessor call
+	Err    error         // the error of the FindSuccessor call, nil if it succeeds
+}
+
+// LookupTrace is the path of a lookup, hop by hop.
+type LookupTrace struct {
+	Identifier *big.Int
+	Hops       []TraceHop
+}
+
+// record appends a hop to the trace, a nil trace records nothing.
+func (trace *LookupTrace) record(step int, nodeInfo *NodeInfo, reply *FindSuccessorReply, rtt time.Duration, err error) {
+	if trace == nil {
+		return
+	}
+	hop := TraceHop{
+		Step: step,
+		Node: *nodeInfo,
+		RTT:  rtt,
+		Err:  err,
+	}
+	if err == nil {
+		hop.Found = reply.Found
+		hop.Next = reply.NodeInfo
+		hop.Source = reply.Source
+	}
+	trace.Hops = append(trace.Hops, hop)
+}
+
+// TotalRTT sums up the RTT of all the hops.
+func (trace *LookupTrace) TotalRTT() time.Duration {
+	var total time.Duration
+	for _, hop := range trace.Hops {
+		total += hop.RTT
+	}
+	return total
+}
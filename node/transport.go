@@ -0,0 +1,167 @@
+package node
+
+import (
+	"chord/log"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// Transport carries the RPC calls between the hosts.
+// The node only talks to the other nodes through it, so the network can be replaced, e.g. by MemoryNetwork in the tests.
+// An error returned by the remote handler must be an rpc.ServerError, any other error means the peer didn't answer.
+type Transport interface {
+	// Listen makes the services of the server reachable at the address, the address the node advertises.
+	Listen(address string, server *rpc.Server) error
+	// Call makes the RPC call to the service method at the address, and waits at most timeout for the reply.
+	// The call is only sent if check accepts the peer on the connection it goes through.
+	Call(ctx context.Context, address string, serviceMethod string, args interface{}, reply interface{}, timeout time.Duration, check PeerCheck) error
+	// Ping checks that the service at the address answers within timeout, like Call.
+	Ping(ctx context.Context, address string, service string, timeout time.Duration, check PeerCheck) error
+	// StopListening makes the server unreachable, and cuts the peers connected to it.
+	StopListening()
+	// Close closes the connections to the peers, the transport can't make calls anymore.
+	Close()
+}
+
+// PeerCheck checks the public key (DER encoded) the peer presents on the connection a call goes through,
+// the key is nil if the peer presents none (plain TCP). A nil PeerCheck accepts any peer.
+type PeerCheck func(key []byte) error
+
+// tcpTransport is the net/rpc transport over TCP, or TLS if the node uses it.
+// The connections to the peers are pooled, see connPool.
+type tcpTransport struct {
+	tlsConfig *tls.Config // the server TLS configuration, nil for plain TCP
+	pool      *connPool
+
+	listener net.Listener // closed by StopListening
+	conns    map[net.Conn]struct{}
+	muConns  sync.Mutex
+	closeCh  chan struct{} // closed by StopListening
+	stopOnce sync.Once     // StopListening may be called again, e.g. by a forced quit after a refused one
+}
+
+// newTCPTransport creates the TCP transport, dial sets up the connections to the peers (with TLS or not).
+// tlsConfig is the server TLS configuration, nil for plain TCP.
+func newTCPTransport(dial func(ctx context.Context, address string) (net.Conn, error), tlsConfig *tls.Config) *tcpTransport {
+	return &tcpTransport{
+		tlsConfig: tlsConfig,
+		pool:      newConnPool(dial),
+		conns:     make(map[net.Conn]struct{}),
+		closeCh:   make(chan struct{}),
+	}
+}
+
+// peerKey returns the public key of the peer certificate of a TLS connection, a plain TCP connection has none.
+func peerKey(conn net.Conn) []byte {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	certificates := tlsConn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return nil
+	}
+	return certificates[0].RawSubjectPublicKeyInfo
+}
+
+// Listen listens on the port of the address, on all the interfaces, and serves the connections in a separate goroutine.
+func (transport *tcpTransport) Listen(address string, server *rpc.Server) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	var listener net.Listener
+	if transport.tlsConfig != nil {
+		listener, err = tls.Listen("tcp", ":"+port, transport.tlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", ":"+port)
+	}
+	if err != nil {
+		return err
+	}
+	transport.listener = listener
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				select {
+				case <-transport.closeCh:
+					return // the listener is closed by StopListening, stop accepting
+				default:
+				}
+				log.Info("Failed to accept connection: %v", err)
+				continue
+			}
+			go transport.serveConn(server, conn)
+		}
+	}()
+	return nil
+}
+
+// serveConn serves the RPC requests of the connection until it is closed,
+// the connection is tracked so that StopListening can cut the peers that keep a pooled connection.
+func (transport *tcpTransport) serveConn(server *rpc.Server, conn net.Conn) {
+	transport.muConns.Lock()
+	transport.conns[conn] = struct{}{}
+	transport.muConns.Unlock()
+
+	server.ServeConn(conn)
+
+	transport.muConns.Lock()
+	delete(transport.conns, conn)
+	transport.muConns.Unlock()
+}
+
+// Call makes the call on the pooled connection of the address, the peer is checked on that connection.
+func (transport *tcpTransport) Call(ctx context.Context, address string, serviceMethod string, args interface{}, reply interface{}, timeout time.Duration, check PeerCheck) error {
+	return transport.pool.call(ctx, address, serviceMethod, args, reply, timeout, check)
+}
+
+// Ping calls the PingRPC method of the service, on the pooled connection, so it doesn't cost a new handshake.
+func (transport *tcpTransport) Ping(ctx context.Context, address string, service string, timeout time.Duration, check PeerCheck) error {
+	return transport.pool.call(ctx, address, service+".PingRPC", &Empty{}, &Empty{}, timeout, check)
+}
+
+// StopListening closes the listener, if it is started, and all the served connections. It can be called again.
+func (transport *tcpTransport) StopListening() {
+	transport.stopOnce.Do(func() {
+		close(transport.closeCh)
+		if transport.listener != nil {
+			if err := transport.listener.Close(); err != nil {
+				log.Error("Failed to close the listener: %v", err)
+			}
+		}
+	})
+
+	transport.muConns.Lock()
+	defer transport.muConns.Unlock()
+	for conn := range transport.conns {
+		_ = conn.Close()
+	}
+}
+
+// Close closes the pooled connections.
+func (transport *tcpTransport) Close() {
+	transport.pool.close()
+}
+
+// dial sets up a connection to the address, with TLS if `node.TLSBool` is true.
+func (node *Node) dial(ctx context.Context, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if node.tlsBool {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: node.clientTLSConfig}
+		return tlsDialer.DialContext(ctx, "tcp", address)
+	}
+	return dialer.DialContext(ctx, "tcp", address)
+}
+
+// errUnreachable is the error of a call that can't reach the peer, it is not an rpc.ServerError.
+func errUnreachable(address string, reason string) error {
+	return fmt.Errorf("dial %s: %s", address, reason)
+}
@@ -0,0 +1,251 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/rpc"
+	"sync"
+	"time"
+)
+
+// MemoryNetwork is an in-process network: the nodes attached to it call each other directly,
+// without binding any port, so the ring tests are fast and don't depend on the machine.
+// It can add latency to the calls, lose some of them, and partition the nodes.
+// The random choices come from a seeded source, so the same seed gives the same losses and latencies.
+type MemoryNetwork struct {
+	mu         sync.Mutex
+	servers    map[string]*rpc.Server // the listening servers by address
+	random     *rand.Rand
+	minLatency time.Duration
+	maxLatency time.Duration
+	loss       float64           // the probability that a call is lost
+	groups     map[string]int    // the group of each partitioned address
+	keys       map[string][]byte // the public key presented at each address, see SetKey
+}
+
+// NewMemoryNetwork creates an empty in-process network, seed drives the injected latency and loss.
+func NewMemoryNetwork(seed int64) *MemoryNetwork {
+	return &MemoryNetwork{
+		servers: make(map[string]*rpc.Server),
+		keys:    make(map[string][]byte),
+		random:  rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Transport returns the transport of the node advertising the address, see WithTransport.
+func (network *MemoryNetwork) Transport(address string) Transport {
+	return &memoryTransport{network: network, address: address}
+}
+
+// SetLatency makes every call take a random time in [min, max] before it is served.
+func (network *MemoryNetwork) SetLatency(min, max time.Duration) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	network.minLatency, network.maxLatency = min, max
+}
+
+// SetLoss makes a call fail with the probability loss, as if the message were lost.
+// A lost call fails at once, instead of waiting for its timeout, so the tests stay fast.
+func (network *MemoryNetwork) SetLoss(loss float64) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	network.loss = loss
+}
+
+// Partition splits the network into groups of addresses, the addresses of different groups can't reach each other.
+// An address in no group still reaches everyone.
+func (network *MemoryNetwork) Partition(groups ...[]string) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	network.groups = make(map[string]int)
+	for i, group := range groups {
+		for _, address := range group {
+			network.groups[address] = i
+		}
+	}
+}
+
+// Heal removes the partition.
+func (network *MemoryNetwork) Heal() {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	network.groups = nil
+}
+
+// SetKey makes the node at the address present the public key (DER encoded), as the certificate of a TLS handshake would.
+func (network *MemoryNetwork) SetKey(address string, key []byte) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	network.keys[address] = key
+}
+
+// key returns the public key of the node at the address, nil if it has none.
+func (network *MemoryNetwork) key(address string) []byte {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	return network.keys[address]
+}
+
+// route decides the fate of a call from one address to another: the server to call, the latency,
+// or an error if the call doesn't get through.
+func (network *MemoryNetwork) route(from string, to string) (*rpc.Server, time.Duration, error) {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	fromGroup, fromPartitioned := network.groups[from]
+	toGroup, toPartitioned := network.groups[to]
+	if fromPartitioned && toPartitioned && fromGroup != toGroup {
+		return nil, 0, errUnreachable(to, "partitioned")
+	}
+	server, ok := network.servers[to]
+	if !ok {
+		return nil, 0, errUnreachable(to, "connection refused")
+	}
+	if network.loss > 0 && network.random.Float64() < network.loss {
+		return nil, 0, errUnreachable(to, "message lost")
+	}
+	latency := network.minLatency
+	if network.maxLatency > network.minLatency {
+		latency += time.Duration(network.random.Int63n(int64(network.maxLatency - network.minLatency)))
+	}
+	return server, latency, nil
+}
+
+// memoryTransport is the transport of one node on a MemoryNetwork.
+type memoryTransport struct {
+	network *MemoryNetwork
+	address string // the address of the node, used to apply the partitions
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Listen attaches the server to the network at the address.
+func (transport *memoryTransport) Listen(address string, server *rpc.Server) error {
+	transport.network.mu.Lock()
+	defer transport.network.mu.Unlock()
+	if _, ok := transport.network.servers[address]; ok {
+		return fmt.Errorf("listen %s: address already in use", address)
+	}
+	transport.network.servers[address] = server
+	return nil
+}
+
+// Call serves the call with the server of the address, in the caller's process.
+// The args and the reply are copied with gob, as on the wire, so the nodes never share memory.
+func (transport *memoryTransport) Call(ctx context.Context, address string, serviceMethod string, args interface{}, reply interface{}, timeout time.Duration, check PeerCheck) error {
+	transport.mu.Lock()
+	closed := transport.closed
+	transport.mu.Unlock()
+	if closed {
+		return fmt.Errorf("transport is closed")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	server, latency, err := transport.network.route(transport.address, address)
+	if err != nil {
+		return err
+	}
+	if check != nil {
+		if err := check(transport.network.key(address)); err != nil {
+			return err
+		}
+	}
+	var body bytes.Buffer
+	if err := gob.NewEncoder(&body).Encode(args); err != nil {
+		return fmt.Errorf("rpc call %s to %s: %w", serviceMethod, address, err)
+	}
+	codec := &memoryCodec{serviceMethod: serviceMethod, args: body.Bytes()}
+
+	done := make(chan error, 1)
+	go func() {
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			done <- ctx.Err()
+			return
+		}
+		done <- server.ServeRequest(codec)
+	}()
+	select {
+	case err := <-done:
+		// an unknown method is answered with an error, like a TCP server does, and also returned by ServeRequest
+		if err != nil && codec.err == "" {
+			return fmt.Errorf("rpc call %s to %s: %w", serviceMethod, address, err)
+		}
+	case <-ctx.Done():
+		return fmt.Errorf("rpc call %s to %s abandoned: %w", serviceMethod, address, ctx.Err())
+	}
+
+	if codec.err != "" {
+		return rpc.ServerError(codec.err)
+	}
+	return gob.NewDecoder(bytes.NewReader(codec.reply)).Decode(reply)
+}
+
+// Ping calls the PingRPC method of the service.
+func (transport *memoryTransport) Ping(ctx context.Context, address string, service string, timeout time.Duration, check PeerCheck) error {
+	return transport.Call(ctx, address, service+".PingRPC", &Empty{}, &Empty{}, timeout, check)
+}
+
+// StopListening detaches the node's server from the network.
+func (transport *memoryTransport) StopListening() {
+	transport.network.mu.Lock()
+	defer transport.network.mu.Unlock()
+	delete(transport.network.servers, transport.address)
+}
+
+// Close stops the calls of the node.
+func (transport *memoryTransport) Close() {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	transport.closed = true
+}
+
+// memoryCodec is the rpc.ServerCodec of one in-memory call: it reads the request once and keeps the response.
+type memoryCodec struct {
+	serviceMethod string
+	args          []byte // the gob encoded args
+	read          bool
+
+	reply []byte // the gob encoded reply
+	err   string // the error returned by the handler
+}
+
+func (codec *memoryCodec) ReadRequestHeader(request *rpc.Request) error {
+	if codec.read {
+		return io.EOF
+	}
+	codec.read = true
+	request.ServiceMethod = codec.serviceMethod
+	return nil
+}
+
+func (codec *memoryCodec) ReadRequestBody(body interface{}) error {
+	if body == nil {
+		return nil // the request is discarded, e.g. an unknown service
+	}
+	return gob.NewDecoder(bytes.NewReader(codec.args)).Decode(body)
+}
+
+func (codec *memoryCodec) WriteResponse(response *rpc.Response, body interface{}) error {
+	codec.err = response.Error
+	if response.Error != "" {
+		return nil
+	}
+	var reply bytes.Buffer
+	if err := gob.NewEncoder(&reply).Encode(body); err != nil {
+		return err
+	}
+	codec.reply = reply.Bytes()
+	return nil
+}
+
+func (codec *memoryCodec) Close() error {
+	return nil
+}
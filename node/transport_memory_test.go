@@ -0,0 +1,42 @@
+package node
+
+import (
+	"math/big"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMemoryRingWithLatencyAndLoss(t *testing.T) {
+	network := NewMemoryNetwork(1)
+	network.SetLatency(time.Millisecond, 3*time.Millisecond)
+
+	var nodes []*Node
+	for i := 0; i < 8; i++ {
+		var joinNode *Node
+		if i > 0 {
+			joinNode = nodes[0]
+		}
+		nodes = append(nodes, startTestNode(t, network, strconv.Itoa(4170+i), joinNode))
+	}
+	// the ring survives the lost calls, the failure detector doesn't give up a peer for one of them
+	network.SetLoss(0.05)
+	waitForRing(t, nodes, 20*time.Second)
+	network.SetLoss(0)
+
+	// every node finds the same owner for every key
+	for key := int64(0); key < 1024; key += 37 {
+		var owner *NodeInfo
+		for _, node := range nodes {
+			found, err := node.Remote(&node.info).Lookup(node.ctx, big.NewInt(key))
+			if err != nil {
+				t.Fatalf("Lookup of %d from %s failed: %v", key, node.info.Port, err)
+			}
+			if owner == nil {
+				owner = found
+			} else if found.Port != owner.Port {
+				t.Fatalf("Lookup of %d: %s finds %s, but %s finds %s", key, nodes[0].info.Port, owner.Port, node.info.Port, found.Port)
+			}
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package sim
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the virtual clock of a simulation, it only moves when the simulator advances it.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock creates a clock at an arbitrary but fixed time, so two runs see the same times.
+func NewClock() *Clock {
+	return &Clock{now: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// Now returns the virtual time.
+func (clock *Clock) Now() time.Time {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	return clock.now
+}
+
+// Advance moves the virtual time forward.
+func (clock *Clock) Advance(d time.Duration) {
+	clock.mu.Lock()
+	defer clock.mu.Unlock()
+	clock.now = clock.now.Add(d)
+}
@@ -0,0 +1,78 @@
+package sim
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Check checks the ring invariants on the live nodes:
+//  1. the successor of each node is the next live node in identifier order.
+//  2. every stored key is in the storage of its owner, the first live node at or after the key.
+//  3. every stored key is in the backups of at least r other nodes, or of all the other nodes in a smaller ring.
+//
+// It returns the first violation found, nil if the invariants hold.
+func (sim *Simulator) Check() error {
+	sorted := sim.sortedNodes()
+	if len(sorted) == 0 {
+		return nil
+	}
+
+	for i, simNode := range sorted {
+		want := sorted[(i+1)%len(sorted)]
+		got := simNode.node.GetFirstSuccessor()
+		if got.Empty() || got.Identifier.Cmp(want.node.GetInfo().Identifier) != 0 {
+			return fmt.Errorf("the successor of %s is %v, want %s", simNode.address, got, want.address)
+		}
+	}
+
+	keys := make([]string, 0, len(sim.keys))
+	for key := range sim.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	wantBackups := min(sim.config.SuccessorsLength, len(sorted)-1)
+	for _, key := range keys {
+		owner := sim.ownerOf(sorted, key)
+		if !contains(owner.node.GetFilesName(), key) {
+			return fmt.Errorf("%s is not stored on its owner %s", key, owner.address)
+		}
+
+		backups := 0
+		for _, simNode := range sorted {
+			if simNode == owner {
+				continue
+			}
+			for i := 0; i < sim.config.SuccessorsLength; i++ {
+				if contains(simNode.node.GetBackupFilesName(i), key) {
+					backups++
+					break
+				}
+			}
+		}
+		if backups < wantBackups {
+			return fmt.Errorf("%s has %d backups, want at least %d", key, backups, wantBackups)
+		}
+	}
+	return nil
+}
+
+// ownerOf returns the owner of the key among the sorted live nodes.
+func (sim *Simulator) ownerOf(sorted []*simNode, key string) *simNode {
+	identifier := sorted[0].node.GenerateIdentifier(key)
+	for _, simNode := range sorted {
+		if simNode.node.GetInfo().Identifier.Cmp(identifier) >= 0 {
+			return simNode
+		}
+	}
+	return sorted[0] // the key is after the last node, the ring wraps around
+}
+
+func contains(list []string, item string) bool {
+	for _, element := range list {
+		if element == item {
+			return true
+		}
+	}
+	return false
+}
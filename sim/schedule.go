@@ -0,0 +1,115 @@
+package sim
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// EventKind is the kind of a scheduled event.
+type EventKind string
+
+const (
+	EventJoin  EventKind = "join"  // a new node joins the ring
+	EventCrash EventKind = "crash" // a random node stops without telling anyone
+	EventLeave EventKind = "leave" // a random node quits gracefully
+	EventStore EventKind = "store" // a new key is stored in the ring
+)
+
+// Weights are the relative frequencies of the event kinds in a schedule.
+type Weights struct {
+	Join  int
+	Crash int
+	Leave int
+	Store int
+}
+
+// DefaultWeights is an even mix of churn and stores.
+var DefaultWeights = Weights{Join: 3, Crash: 2, Leave: 2, Store: 3}
+
+// Event is one step of a schedule, the target of a crash or a leave is chosen when the event runs,
+// among the nodes alive at that time.
+type Event struct {
+	Kind EventKind
+	Key  string // the key of a store
+}
+
+func (event Event) String() string {
+	if event.Kind == EventStore {
+		return fmt.Sprintf("%s %s", event.Kind, event.Key)
+	}
+	return string(event.Kind)
+}
+
+// Schedule draws a schedule of steps events from the seeded source of the simulation.
+func (sim *Simulator) Schedule(steps int, weights Weights) []Event {
+	total := weights.Join + weights.Crash + weights.Leave + weights.Store
+	events := make([]Event, 0, steps)
+	for i := 0; i < steps; i++ {
+		draw := sim.random.Intn(total)
+		switch {
+		case draw < weights.Join:
+			events = append(events, Event{Kind: EventJoin})
+		case draw < weights.Join+weights.Crash:
+			events = append(events, Event{Kind: EventCrash})
+		case draw < weights.Join+weights.Crash+weights.Leave:
+			events = append(events, Event{Kind: EventLeave})
+		default:
+			events = append(events, Event{Kind: EventStore, Key: "key-" + strconv.Itoa(i)})
+		}
+	}
+	return events
+}
+
+// Apply runs the event. A crash or a leave is skipped when only minSize nodes are left,
+// so the ring never shrinks below it.
+func (sim *Simulator) Apply(event Event, minSize int) error {
+	switch event.Kind {
+	case EventJoin:
+		_, err := sim.Join()
+		return err
+	case EventCrash, EventLeave:
+		if len(sim.nodes) <= minSize {
+			return nil
+		}
+		address := sim.nodes[sim.random.Intn(len(sim.nodes))].address
+		if event.Kind == EventCrash {
+			return sim.Crash(address)
+		}
+		return sim.Leave(address)
+	case EventStore:
+		return sim.Store(event.Key)
+	}
+	return fmt.Errorf("unknown event kind %q", event.Kind)
+}
+
+// Bootstrap creates the ring and makes size-1 nodes join it, letting the ring settle every batch joins.
+func (sim *Simulator) Bootstrap(size int, batch int) error {
+	if err := sim.Create(); err != nil {
+		return err
+	}
+	for sim.Size() < size {
+		for i := 0; i < batch && sim.Size() < size; i++ {
+			if _, err := sim.Join(); err != nil {
+				return err
+			}
+		}
+		if err := sim.Settle(); err != nil {
+			return fmt.Errorf("bootstrap at %d nodes: %w", sim.Size(), err)
+		}
+	}
+	return nil
+}
+
+// Run applies the events one by one, and checks that the ring settles after each of them.
+// It stops at the first event that fails or after which the invariants don't hold.
+func (sim *Simulator) Run(events []Event, minSize int) error {
+	for i, event := range events {
+		if err := sim.Apply(event, minSize); err != nil {
+			return fmt.Errorf("step %d (%v): %w", i, event, err)
+		}
+		if err := sim.Settle(); err != nil {
+			return fmt.Errorf("step %d (%v): %w", i, event, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,323 @@
+// Package sim runs many Chord nodes in one process, on an in-memory network and a virtual clock.
+// The simulator drives the periodic tasks of the nodes itself, injects joins, crashes and graceful leaves
+// from a seeded schedule, and checks the ring invariants after each of them.
+// The nodes time their calls with the virtual clock and draw their random choices from the seed,
+// so the same seed gives the same run, and a failing run can be replayed.
+package sim
+
+import (
+	cfs "chord/cachefilesystem"
+	"chord/node"
+	"context"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Config is the setting of a simulation.
+type Config struct {
+	Seed             int64
+	IdentifierLength int // m, large enough that the node identifiers don't collide
+	SuccessorsLength int // r
+
+	StabilizeInterval        time.Duration // the virtual time between two runs of each task
+	FixFingersInterval       time.Duration
+	CheckPredecessorInterval time.Duration
+	Tick                     time.Duration // the virtual time step of the simulator
+
+	SettleTime time.Duration // the virtual time given to the ring to repair itself after an event
+
+	Dir string // the storage root of the nodes
+}
+
+// DefaultConfig returns a configuration for fast runs, only the storage root is left to the caller.
+func DefaultConfig(seed int64) Config {
+	return Config{
+		Seed:                     seed,
+		IdentifierLength:         32,
+		SuccessorsLength:         3,
+		StabilizeInterval:        100 * time.Millisecond,
+		FixFingersInterval:       100 * time.Millisecond,
+		CheckPredecessorInterval: 100 * time.Millisecond,
+		Tick:                     100 * time.Millisecond,
+		SettleTime:               60 * time.Second,
+	}
+}
+
+// simNode is a live node of the simulation, with the virtual time of its next tasks.
+type simNode struct {
+	node    *node.Node
+	address string
+
+	nextStabilize        time.Time
+	nextFixFingers       time.Time
+	nextCheckPredecessor time.Time
+}
+
+// Simulator is one simulation run.
+type Simulator struct {
+	config  Config
+	clock   *Clock
+	network *node.MemoryNetwork
+	random  *rand.Rand
+
+	nodes    []*simNode        // the live nodes, in the order they joined
+	keys     map[string]bool   // the keys stored in the ring, they must never be lost
+	ports    int               // the number of nodes created so far, it gives the next port
+	contents map[string][]byte // the content of each key
+}
+
+// New creates an empty simulation.
+func New(config Config) *Simulator {
+	return &Simulator{
+		config:   config,
+		clock:    NewClock(),
+		network:  node.NewMemoryNetwork(config.Seed),
+		random:   rand.New(rand.NewSource(config.Seed)),
+		keys:     make(map[string]bool),
+		contents: make(map[string][]byte),
+	}
+}
+
+// Network returns the in-memory network, to inject latency, loss or partitions.
+func (sim *Simulator) Network() *node.MemoryNetwork {
+	return sim.network
+}
+
+// Clock returns the virtual clock.
+func (sim *Simulator) Clock() *Clock {
+	return sim.clock
+}
+
+// Size returns the number of live nodes.
+func (sim *Simulator) Size() int {
+	return len(sim.nodes)
+}
+
+// Close stops all the live nodes.
+func (sim *Simulator) Close() {
+	for _, simNode := range sim.nodes {
+		simNode.node.Close()
+	}
+	sim.nodes = nil
+}
+
+// newNode creates a node with the next free address, it is not in the ring yet.
+func (sim *Simulator) newNode(bootstrap *simNode) (*simNode, error) {
+	sim.ports++
+	port := strconv.Itoa(10000 + sim.ports)
+	address := "10.0.0.1:" + port
+	dir := filepath.Join(sim.config.Dir, port)
+
+	options := []node.Option{
+		node.WithTransport(sim.network.Transport(address)),
+		node.WithClock(sim.clock),
+		node.WithSeed(sim.config.Seed + int64(sim.ports)),
+		node.WithManualScheduling(),
+	}
+	if bootstrap != nil {
+		options = append(options, node.WithBootstrapPeers(node.NodeInfoList{bootstrap.node.GetInfo()}))
+	}
+	chordNode, err := node.NewNode(
+		sim.config.IdentifierLength, sim.config.SuccessorsLength, "10.0.0.1", port, cfs.CacheStorageFactory,
+		filepath.Join(dir, "storage"), filepath.Join(dir, "backup"),
+		sim.config.StabilizeInterval/time.Millisecond, sim.config.FixFingersInterval/time.Millisecond,
+		sim.config.CheckPredecessorInterval/time.Millisecond,
+		false, nil, nil, options...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	// the tasks of the nodes are spread over the interval, like independent processes
+	now := sim.clock.Now()
+	return &simNode{
+		node:                 chordNode,
+		address:              address,
+		nextStabilize:        now.Add(sim.offset(sim.config.StabilizeInterval)),
+		nextFixFingers:       now.Add(sim.offset(sim.config.FixFingersInterval)),
+		nextCheckPredecessor: now.Add(sim.offset(sim.config.CheckPredecessorInterval)),
+	}, nil
+}
+
+// offset returns a random offset in [0, interval), in whole ticks.
+func (sim *Simulator) offset(interval time.Duration) time.Duration {
+	ticks := int64(interval / sim.config.Tick)
+	if ticks <= 1 {
+		return 0
+	}
+	return time.Duration(sim.random.Int63n(ticks)) * sim.config.Tick
+}
+
+// Create starts the ring with its first node.
+func (sim *Simulator) Create() error {
+	simNode, err := sim.newNode(nil)
+	if err != nil {
+		return err
+	}
+	if err := simNode.node.Initialize("create"); err != nil {
+		return err
+	}
+	sim.nodes = append(sim.nodes, simNode)
+	return nil
+}
+
+// Join adds a node to the ring, through a random live node.
+// Like a new process, it tries again while the join hits stale fingers, advancing the clock, at most SettleTime.
+func (sim *Simulator) Join() (string, error) {
+	if len(sim.nodes) == 0 {
+		return "", fmt.Errorf("no node to join through, create the ring first")
+	}
+	var address string
+	err := sim.retry(func() error {
+		simNode, err := sim.newNode(sim.nodes[sim.random.Intn(len(sim.nodes))])
+		if err != nil {
+			return err
+		}
+		if err := simNode.node.Initialize("join"); err != nil {
+			simNode.node.Close()
+			return fmt.Errorf("%s failed to join: %w", simNode.address, err)
+		}
+		sim.nodes = append(sim.nodes, simNode)
+		address = simNode.address
+		return nil
+	})
+	return address, err
+}
+
+// Crash stops the node at the address at once, without telling anyone.
+func (sim *Simulator) Crash(address string) error {
+	index, err := sim.find(address)
+	if err != nil {
+		return err
+	}
+	sim.nodes[index].node.Close()
+	sim.nodes = append(sim.nodes[:index], sim.nodes[index+1:]...)
+	return nil
+}
+
+// Leave makes the node at the address quit gracefully, handing its files over.
+func (sim *Simulator) Leave(address string) error {
+	index, err := sim.find(address)
+	if err != nil {
+		return err
+	}
+	report, err := sim.nodes[index].node.Quit(false, nil)
+	if err != nil {
+		return fmt.Errorf("%s failed to leave: %w", address, err)
+	}
+	// the node is gone even if its handoff is incomplete
+	sim.nodes = append(sim.nodes[:index], sim.nodes[index+1:]...)
+	if !report.Complete() {
+		return fmt.Errorf("%s left without a complete handoff: %+v", address, *report)
+	}
+	return nil
+}
+
+// Store stores the key in the ring, through a random live node.
+// Like a client, it tries again while the lookup hits stale fingers, advancing the clock, at most SettleTime.
+func (sim *Simulator) Store(key string) error {
+	if len(sim.nodes) == 0 {
+		return fmt.Errorf("no node to store %s", key)
+	}
+	entry := sim.nodes[sim.random.Intn(len(sim.nodes))].node
+	content := []byte("content of " + key)
+	if err := sim.retry(func() error { return sim.store(entry, key, content) }); err != nil {
+		return err
+	}
+	sim.keys[key] = true
+	sim.contents[key] = content
+	return nil
+}
+
+func (sim *Simulator) store(entry *node.Node, key string, content []byte) error {
+	ctx := context.Background()
+	owner, err := entry.Remote(entry.GetInfo()).Lookup(ctx, entry.GenerateIdentifier(key))
+	if err != nil {
+		return fmt.Errorf("lookup of %s failed: %w", key, err)
+	}
+	reply, err := entry.Remote(owner).StoreFileContext(ctx, key, content)
+	if err != nil {
+		return fmt.Errorf("store of %s failed: %w", key, err)
+	}
+	if !reply.Success {
+		return fmt.Errorf("%v refused to store %s", owner, key)
+	}
+	return nil
+}
+
+// retry calls f until it succeeds, advancing the clock by a tick after each failure, at most SettleTime.
+// It returns the last error of f.
+func (sim *Simulator) retry(f func() error) error {
+	deadline := sim.clock.Now().Add(sim.config.SettleTime)
+	for {
+		err := f()
+		if err == nil || !sim.clock.Now().Before(deadline) {
+			return err
+		}
+		sim.Advance(sim.config.Tick)
+	}
+}
+
+func (sim *Simulator) find(address string) (int, error) {
+	for i, simNode := range sim.nodes {
+		if simNode.address == address {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("no live node at %s", address)
+}
+
+// Advance moves the virtual clock forward by d, tick by tick, and runs the tasks that are due, node by node.
+func (sim *Simulator) Advance(d time.Duration) {
+	for end := sim.clock.Now().Add(d); sim.clock.Now().Before(end); {
+		sim.clock.Advance(sim.config.Tick)
+		sim.runDueTasks()
+	}
+}
+
+func (sim *Simulator) runDueTasks() {
+	now := sim.clock.Now()
+	// a task may make a node leave the slice, e.g. never, but iterate on a copy to be safe
+	for _, simNode := range append([]*simNode{}, sim.nodes...) {
+		if !simNode.nextStabilize.After(now) {
+			simNode.node.Stabilize()
+			simNode.nextStabilize = now.Add(sim.config.StabilizeInterval)
+		}
+		if !simNode.nextFixFingers.After(now) {
+			simNode.node.FixFingers()
+			simNode.nextFixFingers = now.Add(sim.config.FixFingersInterval)
+		}
+		if !simNode.nextCheckPredecessor.After(now) {
+			simNode.node.CheckPredecessor()
+			simNode.nextCheckPredecessor = now.Add(sim.config.CheckPredecessorInterval)
+		}
+	}
+}
+
+// Settle advances the clock until the invariants hold, at most SettleTime.
+// It returns the violation found at the end if they still don't hold.
+func (sim *Simulator) Settle() error {
+	deadline := sim.clock.Now().Add(sim.config.SettleTime)
+	for {
+		err := sim.Check()
+		if err == nil {
+			return nil
+		}
+		if !sim.clock.Now().Before(deadline) {
+			return fmt.Errorf("the ring is not repaired after %v: %w", sim.config.SettleTime, err)
+		}
+		sim.Advance(sim.config.Tick)
+	}
+}
+
+// sortedNodes returns the live nodes in identifier order.
+func (sim *Simulator) sortedNodes() []*simNode {
+	sorted := append([]*simNode{}, sim.nodes...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].node.GetInfo().Identifier.Cmp(sorted[j].node.GetInfo().Identifier) < 0
+	})
+	return sorted
+}
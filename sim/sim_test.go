@@ -0,0 +1,120 @@
+package sim
+
+import (
+	"chord/log"
+	"chord/node"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChurn(t *testing.T) {
+	size, steps := 100, 40
+	if testing.Short() {
+		size, steps = 20, 10
+	}
+	log.SetDirectory(t.TempDir())
+	config := DefaultConfig(1)
+	config.Dir = t.TempDir()
+	sim := New(config)
+	defer sim.Close()
+
+	start := time.Now()
+	if err := sim.Bootstrap(size, 10); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%d nodes bootstrapped in %v (virtual %v)", sim.Size(), time.Since(start), sim.Clock().Now().Sub(NewClock().Now()))
+
+	// one estimate is rough with r = 3, their mean is close, and the walk is exact
+	var estimates int
+	for _, simNode := range sim.nodes {
+		estimates += simNode.node.EstimateSize()
+	}
+	if mean := float64(estimates) / float64(size); mean < float64(size)/1.5 || mean > float64(size)*1.5 {
+		t.Fatalf("The mean estimate of the ring size is %.1f, want about %d", mean, size)
+	}
+	entry := sim.nodes[0].node
+	reply, err := entry.Remote(sim.nodes[size/2].node.GetInfo()).GetRingSize(false)
+	if err != nil || reply.Estimate < 1 || reply.Exact != 0 {
+		t.Fatalf("The remote estimate of the ring size is %+v (%v), want no walk", reply, err)
+	}
+	reply, err = entry.Remote(sim.nodes[size/2].node.GetInfo()).GetRingSize(true)
+	if err != nil || reply.Exact != size {
+		t.Fatalf("The remotely walked ring size is %d (%v), want %d", reply.Exact, err, size)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	exact, err := entry.CountRing(ctx)
+	cancel()
+	if err != nil || exact != size {
+		t.Fatalf("The walked ring size is %d (%v), want %d", exact, err, size)
+	}
+	t.Logf("Ring size: mean estimate %.1f, walked %d", float64(estimates)/float64(size), exact)
+
+	events := sim.Schedule(steps, DefaultWeights)
+	if err := sim.Run(events, config.SuccessorsLength+1); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("%d events with %d nodes left in %v", len(events), sim.Size(), time.Since(start))
+}
+
+func TestSameSeedSameRun(t *testing.T) {
+	log.SetDirectory(t.TempDir())
+	run := func() string {
+		config := DefaultConfig(7)
+		config.Dir = t.TempDir()
+		sim := New(config)
+		defer sim.Close()
+		if err := sim.Bootstrap(30, 10); err != nil {
+			t.Fatal(err)
+		}
+		if err := sim.Run(sim.Schedule(10, DefaultWeights), config.SuccessorsLength+1); err != nil {
+			t.Fatal(err)
+		}
+		return state(t, sim)
+	}
+	first := run()
+	if second := run(); second != first {
+		t.Fatalf("Two runs with the same seed end in different states:\n%s\n---\n%s", first, second)
+	}
+}
+
+// state formats the virtual time and, for each live node in ring order, its neighbors, its fingers and its files.
+func state(t *testing.T, sim *Simulator) string {
+	var state strings.Builder
+	fmt.Fprintf(&state, "time %v\n", sim.Clock().Now())
+	for _, simNode := range sim.sortedNodes() {
+		chordNode := simNode.node
+		files, err := chordNode.GetAllFiles()
+		if err != nil {
+			t.Fatalf("Failed to read the files of %s: %v", simNode.address, err)
+		}
+		var keys []string
+		for _, file := range files {
+			keys = append(keys, file.Key)
+		}
+		sort.Strings(keys)
+		var fingers node.NodeInfoList
+		for i := 0; i < sim.config.IdentifierLength; i++ {
+			fingers = append(fingers, chordNode.GetFingerEntry(i))
+		}
+		fmt.Fprintf(&state, "%s %v successors %v predecessor %v fingers %v files %v\n", simNode.address, chordNode.GetInfo().Identifier,
+			identifiers(chordNode.GetSuccessors()), identifiers(node.NodeInfoList{chordNode.GetPredecessor()}), identifiers(fingers), keys)
+	}
+	return state.String()
+}
+
+// identifiers returns the identifiers of the list, the empty entries as nil.
+func identifiers(list node.NodeInfoList) []string {
+	var identifiers []string
+	for _, nodeInfo := range list {
+		if nodeInfo.Empty() {
+			identifiers = append(identifiers, "nil")
+			continue
+		}
+		identifiers = append(identifiers, nodeInfo.Identifier.String())
+	}
+	return identifiers
+}
@@ -2,26 +2,65 @@ package tools
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"fmt"
+	"hash"
 	"math/big"
 )
 
-var IdentifierLength = 10 // m, the length of the identifier, default is 10
+// MaxIdentifierLength is the largest m we can use with any hash, the identifier is cut from the digest.
+// The digest of the hash in use may be shorter, see HashBits.
+const MaxIdentifierLength = 256
 
-// you should set the identifier length before using the tools
-// if not, the default value will be used
-func SetIdentifierLength(length int) {
-	IdentifierLength = length
+// The hash functions the identifiers can be cut from, chosen when the ring is created.
+const (
+	HashSHA1      = "sha1"
+	HashSHA256    = "sha256"
+	HashSHA512256 = "sha512/256"
+)
+
+// DefaultHash is the hash of the rings that don't choose one, and of the nodes from before the choice.
+const DefaultHash = HashSHA1
+
+// hashFunction is a hash the identifiers can be cut from.
+type hashFunction struct {
+	new  func() hash.Hash
+	bits int // the length of the digest
+}
+
+var hashFunctions = map[string]hashFunction{
+	HashSHA1:      {sha1.New, 160},
+	HashSHA256:    {sha256.New, 256},
+	HashSHA512256: {sha512.New512_256, 256},
+}
+
+// Infinity is above the identifiers of any ring, 2^MaxIdentifierLength + 1, it marks an empty entry.
+var Infinity = new(big.Int).Add(TwoM(MaxIdentifierLength), big.NewInt(1))
+
+// TwoM returns 2^m, the size of the identifier space of a ring with identifiers of length m.
+func TwoM(length int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(2), big.NewInt(int64(length)), nil)
 }
 
-// 2^m
-var TwoM = new(big.Int).Exp(big.NewInt(2), big.NewInt(int64(IdentifierLength)), nil)
+// Mod returns x mod 2^m in x, x % 2^m == x & (2^m - 1), wiki: https://en.wikipedia.org/wiki/Modulo
+func Mod(x *big.Int, length int) *big.Int {
+	return x.And(x, new(big.Int).Sub(TwoM(length), big.NewInt(1)))
+}
 
-// x % 2^m == x & (2^m - 1), wiki: https://en.wikipedia.org/wiki/Modulo
-var TwoMMinusOne = new(big.Int).Sub(TwoM, big.NewInt(1))
+// CheckHash checks that the identifiers can be cut from the named hash.
+func CheckHash(name string) error {
+	if _, ok := hashFunctions[name]; !ok {
+		return fmt.Errorf("unknown hash %q, want %s, %s or %s", name, HashSHA1, HashSHA256, HashSHA512256)
+	}
+	return nil
+}
 
-// infinity: 2^m + 1
-var Infinity = new(big.Int).Add(TwoM, big.NewInt(1))
+// HashBits returns the length of the digest of the named hash, 0 if it is unknown.
+// The identifiers can't be longer.
+func HashBits(name string) int {
+	return hashFunctions[name].bits
+}
 
 // convert string to *big.Int
 func HexStringToBigInt(str string) (*big.Int, error) {
@@ -31,19 +70,19 @@ func HexStringToBigInt(str string) (*big.Int, error) {
 	return nil, fmt.Errorf("failed to convert string to *big.Int")
 }
 
-// generate hash
-func GenerateHash(elt string) *big.Int {
-	hashes := sha1.New() // use sha1 now, but can be changed to other hash functions
+// generate hash, with the named hash, see CheckHash
+func GenerateHash(elt string, hashName string) *big.Int {
+	hashes := hashFunctions[hashName].new()
 	hashes.Write([]byte(elt))
 	return new(big.Int).SetBytes(hashes.Sum(nil))
 }
 
-// generate identifier, normal situation
-func GenerateIdentifier(name string) *big.Int {
+// generate identifier, normal situation, in a ring cutting its identifiers of length m from the named hash
+func GenerateIdentifier(name string, hashName string, length int) *big.Int {
 	// generate the hash of the name
-	temp := GenerateHash(name)
+	temp := GenerateHash(name, hashName)
 	// return the hash mod 2^m
-	return temp.And(temp, TwoMMinusOne)
+	return Mod(temp, length)
 }
 
 // LessThan returns true if a < b
@@ -66,6 +105,12 @@ func GreaterThanOrEqual(a, b *big.Int) bool {
 	return a.Cmp(b) >= 0
 }
 
+// Distance returns the clockwise distance from a to b on the ring of identifiers of length m, (b - a) mod 2^m
+func Distance(a, b *big.Int, length int) *big.Int {
+	distance := new(big.Int).Sub(b, a)
+	return distance.Mod(distance, TwoM(length))
+}
+
 // InInterval returns true if x is in the interval (a, b) or [a, b] or (a, b] or [a, b).
 func InInterval(x, a, b *big.Int, leftClosed, rightClosed bool) bool {
 	if leftClosed && rightClosed {
@@ -83,13 +128,14 @@ func InInterval(x, a, b *big.Int, leftClosed, rightClosed bool) bool {
 // example 1: (22, 22) means from 22 to mod and 0 to 22,
 // example 2: (22, 12) means from 22 to mod and 0 to 12,
 // example 3: (12, 22) means from 12 to 22.
+// x, a and b are identifiers of the same ring, below its 2^m, so m itself is not needed.
 func ModIntervalCheck(x, a, b *big.Int, leftClosed, rightClosed bool) bool {
-	mod := TwoM
 	if a.Cmp(b) < 0 {
 		// a < b, normal interval, eg. (a, b)
 		return InInterval(x, a, b, leftClosed, rightClosed)
 	} else {
 		// a >= b, mod interval, eg. (a, mod) or [0, b)
-		return InInterval(x, a, mod, leftClosed, false) || InInterval(x, big.NewInt(0), b, true, rightClosed)
+		afterA := GreaterThan(x, a) || (leftClosed && x.Cmp(a) == 0)
+		return afterA || InInterval(x, big.NewInt(0), b, true, rightClosed)
 	}
 }